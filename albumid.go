@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// crockford is the Crockford base32 alphabet used to spell ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ulidGenerator makes ULIDs: a 48-bit millisecond timestamp followed by
+// 80 random bits, spelled as 26 base32 characters so that IDs sort by
+// creation time. IDs made within the same millisecond increment the
+// random part of the previous one, keeping them in order.
+type ulidGenerator struct {
+	mu     sync.Mutex
+	lastMS uint64
+	last   [16]byte
+}
+
+// albumIDs generates IDs for albums created without one.
+var albumIDs ulidGenerator
+
+// newAlbumID returns a new server-generated album ID.
+func newAlbumID() string {
+	return albumIDs.next(time.Now())
+}
+
+func (g *ulidGenerator) next(now time.Time) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := uint64(now.UnixMilli())
+	if ms <= g.lastMS && g.increment() {
+		return encodeULID(g.last)
+	}
+	if ms <= g.lastMS {
+		// The random part overflowed; borrow the next millisecond.
+		ms = g.lastMS + 1
+	}
+	g.lastMS = ms
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], ms)
+	copy(g.last[:6], ts[2:])
+	rand.Read(g.last[6:])
+	return encodeULID(g.last)
+}
+
+// increment adds one to the random part of the last ULID, reporting false
+// if it overflowed.
+func (g *ulidGenerator) increment() bool {
+	for i := len(g.last) - 1; i >= 6; i-- {
+		g.last[i]++
+		if g.last[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeULID spells the 128 bits of id as 26 base32 characters, the
+// first of which carries only the top 3 bits.
+func encodeULID(id [16]byte) string {
+	var out [26]byte
+	for i := range out {
+		var v byte
+		for b := range 5 {
+			pos := i*5 + b - 2 // bit of id, counting from the most significant
+			v <<= 1
+			if pos >= 0 && id[pos/8]>>(7-pos%8)&1 == 1 {
+				v |= 1
+			}
+		}
+		out[i] = crockford[v]
+	}
+	return string(out[:])
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// principalKey is the gin context key holding the authenticated caller.
+const principalKey = "principal"
+
+// authErrorKey is the gin context key holding the reason a request's
+// credentials were rejected.
+const authErrorKey = "auth_error"
+
+// Authentication methods.
+const (
+	authAPIKey = "api_key"
+	authJWT    = "jwt"
+)
+
+var (
+	errNoCredentials = errors.New("authentication is required")
+	errInvalidAPIKey = errors.New("API key is not valid")
+	errAuthScheme    = errors.New("Authorization must be a bearer token")
+	errNoTokens      = errors.New("bearer tokens are not accepted; send an API key in X-API-Key")
+)
+
+// tokenError reports a bearer token that was rejected.
+type tokenError struct{ error }
+
+func (e tokenError) Unwrap() error { return e.error }
+
+// principal is the caller a request was authenticated as.
+type principal struct {
+	Name   string // the API key's name, or the token's subject
+	Method string // authAPIKey or authJWT
+	Role   accessRole
+}
+
+// authenticator checks the API key in the X-API-Key header or the JSON
+// Web Token in the Authorization header of each request.
+type authenticator struct {
+	keys        map[[sha256.Size]byte]principal // by key hash
+	jwt         *jwtVerifier                    // nil if tokens are not accepted
+	rolesClaim  string                          // token claim listing the caller's roles
+	publicReads bool
+}
+
+// newAuthenticator builds the authenticator configured by cfg. API keys
+// are given as name:role:hash, with the hex SHA-256 hash of the key so
+// that the config does not hold the keys themselves. A key given as
+// name:hash is an editor's.
+func newAuthenticator(cfg config) (*authenticator, error) {
+	a := &authenticator{
+		keys:        make(map[[sha256.Size]byte]principal),
+		rolesClaim:  cfg.AuthJWTRolesClaim,
+		publicReads: cfg.AuthPublicReads,
+	}
+	for _, entry := range strings.Split(cfg.AuthAPIKeys, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		name, roleName, hash := parts[0], "editor", parts[len(parts)-1]
+		switch {
+		case len(parts) == 3:
+			roleName = parts[1]
+		case len(parts) != 2:
+			return nil, fmt.Errorf("API key %q: want name:role:sha256-hex", entry)
+		}
+		if name == "" {
+			return nil, fmt.Errorf("API key %q has no name", entry)
+		}
+		role, ok := accessRoles[roleName]
+		if !ok {
+			return nil, fmt.Errorf("API key %q: unknown role %q (want viewer, editor or admin)", name, roleName)
+		}
+		b, err := hex.DecodeString(hash)
+		if err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("API key %q: hash must be %d hex digits", name, 2*sha256.Size)
+		}
+		sum := [sha256.Size]byte(b)
+		if other, ok := a.keys[sum]; ok {
+			return nil, fmt.Errorf("API keys %q and %q are the same key", other.Name, name)
+		}
+		a.keys[sum] = principal{Name: name, Method: authAPIKey, Role: role}
+	}
+
+	var err error
+	a.jwt, err = newJWTVerifier(cfg.AuthJWTSecret, cfg.AuthJWTPublicKey, cfg.AuthJWTIssuer, cfg.AuthJWTAudience)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// enabled reports whether any credentials are configured. Without any,
+// every request is let through.
+func (a *authenticator) enabled() bool {
+	return len(a.keys) > 0 || a.jwt != nil
+}
+
+// authenticate identifies the caller of a request and stores it in the
+// context under principalKey. Requests carrying invalid credentials have
+// the reason stored under authErrorKey instead, and are rejected by
+// authorize once they have counted against their IP address's rate
+// limit, so that credentials cannot be guessed at full speed. Those
+// carrying none are left to authorize.
+func (a *authenticator) authenticate(c *gin.Context) {
+	if !a.enabled() {
+		c.Next()
+		return
+	}
+	p, err := a.identify(c.Request)
+	if err != nil {
+		c.Set(authErrorKey, err)
+		c.Next()
+		return
+	}
+	if p != nil {
+		c.Set(principalKey, *p)
+	}
+	c.Next()
+}
+
+// identify returns the caller named by the credentials in r, or nil if r
+// carries none.
+func (a *authenticator) identify(r *http.Request) (*principal, error) {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		p, ok := a.keys[sha256.Sum256([]byte(key))]
+		if !ok {
+			return nil, errInvalidAPIKey
+		}
+		return &p, nil
+	}
+
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, nil
+	}
+	scheme, token, _ := strings.Cut(header, " ")
+	switch {
+	case !strings.EqualFold(scheme, "Bearer"):
+		return nil, tokenError{errAuthScheme}
+	case a.jwt == nil:
+		return nil, errNoTokens
+	}
+	claims, err := a.jwt.verify(strings.TrimSpace(token), time.Now())
+	if err != nil {
+		return nil, tokenError{err}
+	}
+	// The roles claim is a list of roles, or a string of space-separated
+	// ones like the scope claim.
+	var roles jwtStrings
+	if raw, ok := claims.Extra[a.rolesClaim]; ok {
+		if err := json.Unmarshal(raw, &roles); err != nil {
+			return nil, tokenError{fmt.Errorf("%w: %s claim is not a list of roles", errTokenMalformed, a.rolesClaim)}
+		}
+	}
+	if len(roles) == 1 {
+		roles = strings.Fields(roles[0])
+	}
+	return &principal{Name: claims.Subject, Method: authJWT, Role: highestRole(roles)}, nil
+}
+
+// respondUnauthorized rejects a request whose caller could not be
+// authenticated because of err.
+func respondUnauthorized(c *gin.Context, err error) {
+	challenge := `Bearer realm="albums"`
+	if errors.As(err, new(tokenError)) {
+		challenge += fmt.Sprintf(`, error="invalid_token", error_description=%q`, err.Error())
+	}
+	c.Header("WWW-Authenticate", challenge)
+	render(c, http.StatusUnauthorized, errorResponse{
+		Error:   "unauthorized",
+		Message: err.Error(),
+	})
+	c.Abort()
+}
+
+// isReadMethod reports whether method only reads.
+func isReadMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
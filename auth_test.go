@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hashKey returns the hex SHA-256 hash of an API key, as configured.
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestNewAuthenticatorKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    string
+		want    map[string]principal // by key
+		wantErr string
+	}{
+		{"none", "", map[string]principal{}, ""},
+		{
+			"roles", "ci:viewer:" + hashKey("k1") + ", ops:admin:" + hashKey("k2"),
+			map[string]principal{
+				"k1": {Name: "ci", Method: authAPIKey, Role: accessViewer},
+				"k2": {Name: "ops", Method: authAPIKey, Role: accessAdmin},
+			}, "",
+		},
+		{
+			"editor by default", "bob:" + hashKey("k1"),
+			map[string]principal{"k1": {Name: "bob", Method: authAPIKey, Role: accessEditor}}, "",
+		},
+		{
+			"uppercase hash", "bob:" + strings.ToUpper(hashKey("k1")),
+			map[string]principal{"k1": {Name: "bob", Method: authAPIKey, Role: accessEditor}}, "",
+		},
+		{"key instead of hash", "bob:k1", nil, "hash must be"},
+		{"short hash", "bob:" + hashKey("k1")[:62], nil, "hash must be"},
+		{"unknown role", "bob:owner:" + hashKey("k1"), nil, "unknown role"},
+		{"no name", ":" + hashKey("k1"), nil, "has no name"},
+		{"too many parts", "bob:admin:x:" + hashKey("k1"), nil, "want name:role"},
+		{"same key twice", "a:" + hashKey("k1") + ",b:" + hashKey("k1"), nil, "are the same key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig
+			cfg.AuthAPIKeys = tt.keys
+			a, err := newAuthenticator(cfg)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(a.keys) != len(tt.want) {
+				t.Fatalf("got %d keys, want %d", len(a.keys), len(tt.want))
+			}
+			for key, want := range tt.want {
+				if got, ok := a.keys[sha256.Sum256([]byte(key))]; !ok || got != want {
+					t.Errorf("key %q: got %+v, want %+v", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	cfg := defaultConfig
+	cfg.AuthAPIKeys = "ci:viewer:" + hashKey("viewer-key")
+	cfg.AuthJWTSecret = testJWTSecret
+	cfg.AuthJWTIssuer = "https://issuer.example"
+	cfg.AuthJWTAudience = "albums"
+	a, err := newAuthenticator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noTokens := defaultConfig
+	noTokens.AuthAPIKeys = cfg.AuthAPIKeys
+	keysOnly, err := newAuthenticator(noTokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	token := func(kv ...any) string {
+		kv = append([]any{"exp", now.Add(time.Hour).Unix()}, kv...)
+		return signJWT(t, "HS256", []byte(testJWTSecret), testClaims(kv...))
+	}
+	tests := []struct {
+		name      string
+		a         *authenticator
+		header    string // "Name: value"
+		want      *principal
+		wantErr   error
+		wantToken bool // whether the error is a tokenError
+	}{
+		{"no credentials", a, "", nil, nil, false},
+		{"API key", a, "X-API-Key: viewer-key", &principal{"ci", authAPIKey, accessViewer}, nil, false},
+		{"wrong API key", a, "X-API-Key: other-key", nil, errInvalidAPIKey, false},
+		{"token with role list", a, "Authorization: Bearer " + token("roles", []string{"viewer", "admin"}), &principal{"alice", authJWT, accessAdmin}, nil, false},
+		{"token with role string", a, "Authorization: bearer " + token("roles", "viewer editor"), &principal{"alice", authJWT, accessEditor}, nil, false},
+		{"token with unknown role", a, "Authorization: Bearer " + token("roles", "owner"), &principal{"alice", authJWT, accessNone}, nil, false},
+		{"token without roles", a, "Authorization: Bearer " + token(), &principal{"alice", authJWT, accessNone}, nil, false},
+		{"token with bad roles", a, "Authorization: Bearer " + token("roles", 7), nil, errTokenMalformed, true},
+		{"expired token", a, "Authorization: Bearer " + token("exp", now.Add(-time.Hour).Unix()), nil, errTokenExpired, true},
+		{"basic auth", a, "Authorization: Basic YTpi", nil, errAuthScheme, true},
+		{"tokens not accepted", keysOnly, "Authorization: Bearer " + token(), nil, errNoTokens, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/albums", nil)
+			if name, value, ok := strings.Cut(tt.header, ": "); ok {
+				r.Header.Set(name, value)
+			}
+			got, err := tt.a.identify(r)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if errors.As(err, new(tokenError)) != tt.wantToken {
+				t.Errorf("got token error %v, want %v", !tt.wantToken, tt.wantToken)
+			}
+			switch {
+			case got == nil && tt.want == nil:
+			case got == nil || tt.want == nil || *got != *tt.want:
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
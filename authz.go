@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// accessRole is what a caller may do. Each role may do everything the
+// roles below it may.
+type accessRole int
+
+const (
+	accessNone   accessRole = iota
+	accessViewer            // read albums and the change feed
+	accessEditor            // also create, change and soft-delete albums
+	accessAdmin             // also purge albums, manage webhooks and read the cluster status
+)
+
+var accessRoles = map[string]accessRole{
+	"viewer": accessViewer,
+	"editor": accessEditor,
+	"admin":  accessAdmin,
+}
+
+func (r accessRole) String() string {
+	for name, role := range accessRoles {
+		if role == r {
+			return name
+		}
+	}
+	return "none"
+}
+
+// highestRole returns the most powerful of the named roles, ignoring
+// names that are not roles.
+func highestRole(names []string) accessRole {
+	best := accessNone
+	for _, name := range names {
+		if r := accessRoles[name]; r > best {
+			best = r
+		}
+	}
+	return best
+}
+
+// routePolicy gives the role needed to call method on path, a gin route
+// template, when applies is nil or reports true. An empty method matches
+// every method, and a path ending in "/*" also matches the routes below
+// it.
+type routePolicy struct {
+	method  string
+	path    string
+	applies func(*gin.Context) bool
+	role    accessRole
+}
+
+// policies lists the role each route needs; the first matching entry is
+// used. Reads that are not listed need viewer and other requests admin,
+// so that a new route is closed until it is listed here.
+var policies = []routePolicy{
+	{http.MethodPost, "/albums", nil, accessEditor},
+	{http.MethodPost, "/albums:verb", nil, accessEditor},
+	{http.MethodPut, "/albums/:id", nil, accessEditor},
+	{http.MethodPatch, "/albums/:id", nil, accessEditor},
+	{http.MethodDelete, "/albums/:id", hardDelete, accessAdmin},
+	{http.MethodDelete, "/albums/:id", nil, accessEditor},
+	{http.MethodPost, "/albums/:id/restore", nil, accessEditor},
+	{"", "/webhooks/*", nil, accessAdmin},
+	{http.MethodGet, "/cluster/status", nil, accessAdmin},
+}
+
+// hardDelete reports whether a DELETE purges the album.
+func hardDelete(c *gin.Context) bool {
+	hard, _ := strconv.ParseBool(c.Query("hard"))
+	return hard
+}
+
+// requiredRole returns the role the request's route needs.
+func requiredRole(c *gin.Context) accessRole {
+	method, path := c.Request.Method, c.FullPath()
+	for _, p := range policies {
+		if p.method != "" && p.method != method {
+			continue
+		}
+		if prefix, ok := strings.CutSuffix(p.path, "/*"); ok {
+			if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+				continue
+			}
+		} else if path != p.path {
+			continue
+		}
+		if p.applies == nil || p.applies(c) {
+			return p.role
+		}
+	}
+	if isReadMethod(method) {
+		return accessViewer
+	}
+	return accessAdmin
+}
+
+// authorize lets a request through if its caller has the role its route
+// needs, or the route only needs viewer and reads are public. Requests
+// whose credentials authenticate rejected are answered 401 whatever
+// their route.
+func (a *authenticator) authorize(c *gin.Context) {
+	if v, ok := c.Get(authErrorKey); ok {
+		respondUnauthorized(c, v.(error))
+		return
+	}
+	if !a.enabled() || c.FullPath() == "" {
+		// Requests matching no route get their 404 regardless.
+		c.Next()
+		return
+	}
+	need := requiredRole(c)
+	if need == accessViewer && a.publicReads {
+		c.Next()
+		return
+	}
+	v, ok := c.Get(principalKey)
+	if !ok {
+		respondUnauthorized(c, errNoCredentials)
+		return
+	}
+	if p := v.(principal); p.Role < need {
+		render(c, http.StatusForbidden, errorResponse{
+			Error:   "forbidden",
+			Message: fmt.Sprintf("%s %s requires the %s role; %s has %s", c.Request.Method, c.Request.URL.RequestURI(), need, p.Name, p.Role),
+		})
+		c.Abort()
+		return
+	}
+	c.Next()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestAuthorize(t *testing.T) {
+	cfg := testConfig()
+	cfg.AuthAPIKeys = strings.Join([]string{
+		"reader:viewer:" + hashKey("viewer-key"),
+		"writer:editor:" + hashKey("editor-key"),
+		"ops:admin:" + hashKey("admin-key"),
+	}, ",")
+	cfg.AuthPublicReads = false
+	const album = `{"title":"Blue Train","artist":"John Coltrane","price":"19.99"}`
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		allow  string // least role allowed
+	}{
+		{"list", http.MethodGet, "/albums", "", "viewer"},
+		{"read", http.MethodGet, "/albums/1", "", "viewer"},
+		{"create", http.MethodPost, "/albums", album, "editor"},
+		{"replace", http.MethodPut, "/albums/1", album, "editor"},
+		{"soft delete", http.MethodDelete, "/albums/1", "", "editor"},
+		{"purge", http.MethodDelete, "/albums/1?hard=true", "", "admin"},
+		{"webhooks", http.MethodGet, "/webhooks", "", "admin"},
+	}
+	keys := []string{"viewer", "editor", "admin"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, role := range keys {
+				h := newTestApp(t, cfg, nil).router
+				w := send(h, tt.method, tt.target, tt.body, "X-API-Key", role+"-key", "If-Match", "*")
+				if i < slices.Index(keys, tt.allow) {
+					e := wantError(t, w, http.StatusForbidden, "forbidden")
+					if !strings.Contains(e.Message, "requires the "+tt.allow+" role") {
+						t.Errorf("%s: got message %q, want it to name the %s role", role, e.Message, tt.allow)
+					}
+					continue
+				}
+				if w.Code == http.StatusForbidden || w.Code == http.StatusUnauthorized {
+					t.Errorf("%s: got status %d: %s", role, w.Code, w.Body)
+				}
+			}
+
+			// Without credentials every route needs them.
+			h := newTestApp(t, cfg, nil).router
+			wantError(t, send(h, tt.method, tt.target, tt.body, "If-Match", "*"), http.StatusUnauthorized, "unauthorized")
+		})
+	}
+}
+
+func TestAuthorizePublicReads(t *testing.T) {
+	cfg := testConfig()
+	cfg.AuthAPIKeys = "writer:editor:" + hashKey("editor-key")
+	h := newTestApp(t, cfg, nil).router
+
+	wantStatus(t, send(h, http.MethodGet, "/albums/1", ""), http.StatusOK)
+	wantError(t, send(h, http.MethodPost, "/albums", `{"title":"T","artist":"A","price":1}`), http.StatusUnauthorized, "unauthorized")
+	wantError(t, send(h, http.MethodGet, "/webhooks", ""), http.StatusUnauthorized, "unauthorized")
+	wantError(t, send(h, http.MethodGet, "/webhooks", "", "X-API-Key", "editor-key"), http.StatusForbidden, "forbidden")
+}
@@ -0,0 +1,791 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// changeLogSize is how many recent changes are kept for followers to
+	// catch up from. A follower further behind copies a full snapshot.
+	changeLogSize = 10000
+
+	// maxChangesPerPoll caps the changes returned to one follower poll.
+	maxChangesPerPoll = 1000
+
+	// replicationPollWait is how long the leader holds a follower's poll
+	// open waiting for a new change.
+	replicationPollWait = 10 * time.Second
+
+	// replicationRetry is how long a follower waits after a failed poll.
+	replicationRetry = time.Second
+
+	// forwardSyncTimeout bounds how long a follower waits, after the
+	// leader accepts a forwarded write, for the write to reach it.
+	forwardSyncTimeout = 2 * time.Second
+)
+
+// Cluster roles.
+const (
+	roleStandalone = "standalone"
+	roleLeader     = "leader"
+	roleFollower   = "follower"
+)
+
+// change is one write to the catalog, numbered by its position in the
+// leader's changeLog.
+type change struct {
+	Index uint64         `json:"index"`
+	Op    string         `json:"op"` // "put" or "purge"
+	ID    string         `json:"id"`
+	Album *snapshotAlbum `json:"album,omitempty"`
+}
+
+// changeLog holds the most recent changes to the catalog. Its epoch names
+// one run of the leader. Each epoch numbers its changes on from the time
+// it began in microseconds, so indexes, which also serve as change feed
+// event IDs, keep increasing when the leader restarts. Changes reported
+// by a sharedStore keep the store's numbering, which may skip indexes.
+type changeLog struct {
+	mu      sync.Mutex
+	epoch   string
+	base    uint64        // index the epoch began at
+	floor   uint64        // newest index no longer held
+	last    uint64        // index of the newest change
+	entries []change      // recent changes, in index order
+	grown   chan struct{} // closed and replaced on every append
+}
+
+func newChangeLog(epoch string, base uint64) *changeLog {
+	return &changeLog{epoch: epoch, base: base, floor: base, last: base, grown: make(chan struct{})}
+}
+
+// newEpoch returns a random epoch for a new leader changeLog.
+func newEpoch() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// append adds c to the log and returns it. A change without an index is
+// numbered after the newest one; a replicated change keeps the index the
+// leader gave it.
+func (l *changeLog) append(c change) change {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if c.Index == 0 {
+		c.Index = l.last + 1
+	}
+	l.last = c.Index
+	l.entries = append(l.entries, c)
+	if n := len(l.entries); n >= 2*changeLogSize {
+		l.floor = l.entries[n-changeLogSize-1].Index
+		l.entries = slices.Clone(l.entries[n-changeLogSize:])
+	}
+	close(l.grown)
+	l.grown = make(chan struct{})
+	return c
+}
+
+// since returns up to limit changes following index after. It reports
+// false if after is not a position in this log, either because changes
+// following it were already dropped or because it is beyond the newest.
+func (l *changeLog) since(after uint64, limit int) ([]change, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if after < l.floor || after > l.last {
+		return nil, false
+	}
+	i, _ := slices.BinarySearchFunc(l.entries, after+1, func(c change, index uint64) int {
+		return cmp.Compare(c.Index, index)
+	})
+	return slices.Clone(l.entries[i:min(len(l.entries), i+limit)]), true
+}
+
+// wait blocks until the log holds a change following index after or ctx
+// is done.
+func (l *changeLog) wait(ctx context.Context, after uint64) {
+	l.mu.Lock()
+	if l.last > after {
+		l.mu.Unlock()
+		return
+	}
+	grown := l.grown
+	l.mu.Unlock()
+	select {
+	case <-grown:
+	case <-ctx.Done():
+	}
+}
+
+// position returns the log's epoch and the index of its newest change.
+func (l *changeLog) position() (string, uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.epoch, l.last
+}
+
+// changes returns how many changes the epoch has seen.
+func (l *changeLog) changes() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.last - l.base
+}
+
+// reset empties the log and moves it to position index of epoch.
+func (l *changeLog) reset(epoch string, index uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.epoch = epoch
+	l.floor = index
+	l.last = index
+	l.entries = nil
+}
+
+// clusterSnapshot is every album, trashed ones included, as of position
+// Index of the leader's changeLog.
+type clusterSnapshot struct {
+	Epoch  string          `json:"epoch"`
+	Index  uint64          `json:"index"`
+	Albums []snapshotAlbum `json:"albums"`
+}
+
+// changeBatch is the leader's answer to a follower poll.
+type changeBatch struct {
+	Epoch   string   `json:"epoch"`
+	Index   uint64   `json:"index"` // newest change on the leader
+	Changes []change `json:"changes"`
+}
+
+// loggedStore is an AlbumStore that records every change to the store it
+// wraps in a changeLog, and applies changes replicated from the leader.
+type loggedStore struct {
+	AlbumStore
+	replica replica
+	log     *changeLog
+	shared  bool // the store reports every change itself; see sharedStore
+
+	mu sync.Mutex // orders writes so the log sees them in store order
+}
+
+func newLoggedStore(store replicaStore, log *changeLog) *loggedStore {
+	s := &loggedStore{AlbumStore: store, replica: store, log: log}
+	if shared, ok := store.(sharedStore); ok {
+		s.shared = shared.watch(s.observe)
+	}
+	return s
+}
+
+// observe records changes reported by a shared store, starting the log
+// at the store's position on the first call.
+func (s *loggedStore) observe(after uint64, changes []change) {
+	if len(changes) == 0 {
+		epoch, _ := s.log.position()
+		s.log.reset(epoch, after)
+	}
+	for _, c := range changes {
+		s.log.append(c)
+	}
+}
+
+// record logs a write made through s, which a shared store has already
+// reported.
+func (s *loggedStore) record(c change) {
+	if !s.shared {
+		s.log.append(c)
+	}
+}
+
+// putChange returns the change that stores a.
+func putChange(a album) change {
+	return change{Op: "put", ID: a.ID, Album: &snapshotAlbum{album: a, Seq: a.Seq}}
+}
+
+func (s *loggedStore) Create(a album) (album, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, err := s.AlbumStore.Create(a)
+	if err != nil {
+		return a, err
+	}
+	s.record(putChange(a))
+	return a, nil
+}
+
+func (s *loggedStore) CreateBatch(albums []album) ([]album, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	albums, err := s.AlbumStore.CreateBatch(albums)
+	if err != nil {
+		return albums, err
+	}
+	for _, a := range albums {
+		s.record(putChange(a))
+	}
+	return albums, nil
+}
+
+func (s *loggedStore) Update(a album, ifRev revision) (album, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, err := s.AlbumStore.Update(a, ifRev)
+	if err != nil {
+		return a, err
+	}
+	s.record(putChange(a))
+	return a, nil
+}
+
+func (s *loggedStore) Delete(id string, ifRev revision) (album, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, err := s.AlbumStore.Delete(id, ifRev)
+	if err != nil {
+		return a, err
+	}
+	s.record(putChange(a))
+	return a, nil
+}
+
+func (s *loggedStore) Restore(id string) (album, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, err := s.AlbumStore.Restore(id)
+	if err != nil {
+		return a, err
+	}
+	s.record(putChange(a))
+	return a, nil
+}
+
+func (s *loggedStore) Purge(id string, ifRev revision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.AlbumStore.Purge(id, ifRev); err != nil {
+		return err
+	}
+	s.record(change{Op: "purge", ID: id})
+	return nil
+}
+
+// applyChange stores a change replicated from the leader and records it.
+func (s *loggedStore) applyChange(c change) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	switch {
+	case c.Op == "put" && c.Album != nil:
+		a := c.Album.album
+		a.Seq = c.Album.Seq
+		err = s.replica.apply(a)
+	case c.Op == "purge":
+		err = s.replica.discard(c.ID)
+	default:
+		err = fmt.Errorf("unknown change op %q", c.Op)
+	}
+	if err != nil {
+		return err
+	}
+	s.log.append(c)
+	return nil
+}
+
+// snapshot returns every album together with the log position it
+// reflects.
+func (s *loggedStore) snapshot() (clusterSnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	live, err := s.List()
+	if err != nil {
+		return clusterSnapshot{}, err
+	}
+	trash, err := s.Trash()
+	if err != nil {
+		return clusterSnapshot{}, err
+	}
+	all := append(live, trash...)
+	slices.SortFunc(all, func(a, b album) int { return cmp.Compare(a.Seq, b.Seq) })
+
+	snap := clusterSnapshot{Albums: make([]snapshotAlbum, len(all))}
+	snap.Epoch, snap.Index = s.log.position()
+	for i, a := range all {
+		snap.Albums[i] = snapshotAlbum{album: a, Seq: a.Seq}
+	}
+	return snap, nil
+}
+
+// restore replaces the catalog with a snapshot taken on the leader.
+func (s *loggedStore) restore(snap clusterSnapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	albums := make([]album, len(snap.Albums))
+	for i, a := range snap.Albums {
+		albums[i] = a.album
+		albums[i].Seq = a.Seq
+	}
+	if err := s.replica.reset(albums); err != nil {
+		return err
+	}
+	s.log.reset(snap.Epoch, snap.Index)
+	return nil
+}
+
+// cluster is this instance's place in a group of album servers sharing
+// one catalog. The first configured peer is the leader: it takes every
+// write and records it in its changeLog, which the followers poll and
+// apply to their own copy. Followers serve reads themselves and forward
+// writes to the leader.
+type cluster struct {
+	self    string
+	leader  string // empty when running standalone
+	peers   []string
+	peerIPs []string        // addresses of the peers
+	isPeer  map[string]bool // by address
+	store   *loggedStore
+	client  *http.Client
+
+	ctx  context.Context // canceled on shutdown
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+
+	syncMu sync.Mutex // serializes applying polled changes and snapshots
+
+	mu          sync.Mutex
+	leaderIndex uint64    // newest change seen on the leader (followers)
+	lastContact time.Time // last successful poll of the leader (followers)
+	lastErr     string
+	progress    map[string]followerProgress // by follower URL (leader)
+}
+
+// followerProgress is how far a follower had replicated at its last poll.
+type followerProgress struct {
+	index uint64
+	seen  time.Time
+}
+
+// newCluster sets up this instance as self among peers, the comma-separated
+// base URLs of every instance, leader first. With no peers it runs
+// standalone.
+func newCluster(self, peers string, store replicaStore) (*cluster, error) {
+	cl := &cluster{
+		self:     strings.TrimRight(strings.TrimSpace(self), "/"),
+		client:   &http.Client{Timeout: replicationPollWait + 10*time.Second},
+		progress: make(map[string]followerProgress),
+	}
+	for _, p := range strings.Split(peers, ",") {
+		if p = strings.TrimRight(strings.TrimSpace(p), "/"); p != "" {
+			if _, err := url.ParseRequestURI(p); err != nil {
+				return nil, fmt.Errorf("cluster peer %q: %w", p, err)
+			}
+			cl.peers = append(cl.peers, p)
+		}
+	}
+	if len(cl.peers) > 0 {
+		if !slices.Contains(cl.peers, cl.self) {
+			return nil, fmt.Errorf("cluster self %q is not one of the cluster peers", cl.self)
+		}
+		cl.leader = cl.peers[0]
+	}
+	if err := cl.resolvePeers(); err != nil {
+		return nil, err
+	}
+
+	epoch, base := newEpoch(), uint64(time.Now().UnixMicro())
+	if cl.role() == roleFollower {
+		// Force a full copy from the leader before the first poll.
+		epoch, base = "", 0
+	}
+	cl.store = newLoggedStore(store, newChangeLog(epoch, base))
+	cl.ctx, cl.stop = context.WithCancel(context.Background())
+	return cl, nil
+}
+
+// resolvePeers looks up the addresses of the cluster's instances.
+func (cl *cluster) resolvePeers() error {
+	cl.isPeer = make(map[string]bool)
+	for _, p := range cl.peers {
+		u, err := url.Parse(p)
+		if err != nil {
+			return err
+		}
+		addrs, err := net.LookupHost(u.Hostname())
+		if err != nil {
+			return fmt.Errorf("cluster peer %s: %w", p, err)
+		}
+		for _, a := range addrs {
+			if !cl.isPeer[a] {
+				cl.isPeer[a] = true
+				cl.peerIPs = append(cl.peerIPs, a)
+			}
+		}
+	}
+	return nil
+}
+
+func (cl *cluster) role() string {
+	switch cl.leader {
+	case "":
+		return roleStandalone
+	case cl.self:
+		return roleLeader
+	}
+	return roleFollower
+}
+
+// start begins replicating from the leader if this instance follows it.
+func (cl *cluster) start() {
+	if cl.role() != roleFollower {
+		return
+	}
+	cl.wg.Add(1)
+	go func() {
+		defer cl.wg.Done()
+		cl.follow()
+	}()
+}
+
+// shutdown ends open polls and waits for replication to stop.
+func (cl *cluster) shutdown() {
+	cl.stop()
+	cl.wg.Wait()
+}
+
+// follow polls the leader for changes until shutdown.
+func (cl *cluster) follow() {
+	for cl.ctx.Err() == nil {
+		err := cl.sync(cl.ctx, replicationPollWait)
+		if cl.ctx.Err() != nil {
+			return
+		}
+
+		msg := ""
+		if err != nil {
+			msg = err.Error()
+		}
+		cl.mu.Lock()
+		if msg != cl.lastErr && msg != "" {
+			log.Printf("replicate from %s: %v", cl.leader, err)
+		}
+		cl.lastErr = msg
+		cl.mu.Unlock()
+
+		if err != nil {
+			select {
+			case <-time.After(replicationRetry):
+			case <-cl.ctx.Done():
+			}
+		}
+	}
+}
+
+// sync fetches the changes made on the leader since the last one applied
+// here, waiting up to wait for one if there are none, and applies them.
+// When the leader's log no longer reaches back that far, or belongs to a
+// different run of the leader, it copies a full snapshot instead.
+func (cl *cluster) sync(ctx context.Context, wait time.Duration) error {
+	epoch, after := cl.store.log.position()
+	if epoch == "" {
+		return cl.resync(ctx)
+	}
+
+	q := url.Values{
+		"epoch": {epoch},
+		"after": {strconv.FormatUint(after, 10)},
+		"wait":  {wait.String()},
+		"node":  {cl.self},
+	}
+	var batch changeBatch
+	status, err := cl.get(ctx, "/cluster/changes?"+q.Encode(), &batch)
+	if status == http.StatusGone {
+		return cl.resync(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	cl.syncMu.Lock()
+	defer cl.syncMu.Unlock()
+	epoch, last := cl.store.log.position()
+	if batch.Epoch != epoch {
+		// A snapshot was copied while this poll was open.
+		return nil
+	}
+	for _, c := range batch.Changes {
+		if c.Index <= last {
+			continue
+		}
+		if c.Index != last+1 {
+			break
+		}
+		if err := cl.store.applyChange(c); err != nil {
+			return fmt.Errorf("apply change %d: %w", c.Index, err)
+		}
+		last = c.Index
+	}
+	cl.contacted(batch.Index)
+	return nil
+}
+
+// resync replaces the local catalog with a snapshot from the leader.
+func (cl *cluster) resync(ctx context.Context) error {
+	var snap clusterSnapshot
+	if _, err := cl.get(ctx, "/cluster/snapshot", &snap); err != nil {
+		return err
+	}
+
+	cl.syncMu.Lock()
+	defer cl.syncMu.Unlock()
+	if err := cl.store.restore(snap); err != nil {
+		return fmt.Errorf("restore snapshot: %w", err)
+	}
+	cl.mu.Lock()
+	cl.leaderIndex = 0
+	cl.mu.Unlock()
+	cl.contacted(snap.Index)
+	log.Printf("copied %d albums from leader %s at change %d", len(snap.Albums), cl.leader, snap.Index)
+	return nil
+}
+
+// contacted records a successful poll that saw the leader at index.
+func (cl *cluster) contacted(index uint64) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.leaderIndex = max(cl.leaderIndex, index)
+	cl.lastContact = time.Now()
+}
+
+// get fetches path from the leader and decodes its JSON body into dst.
+// It returns the response status along with any error.
+func (cl *cluster) get(ctx context.Context, path string, dst any) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cl.leader+path, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := cl.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e errorResponse
+		json.NewDecoder(resp.Body).Decode(&e)
+		return resp.StatusCode, fmt.Errorf("leader answered %s: %s", resp.Status, e.Message)
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(dst)
+}
+
+// forwardWrites sends requests that may change the catalog to the leader
+// when this instance is a follower. Once the leader accepts a write, the
+// follower catches up before answering, so the client can read the write
+// back from the same instance.
+func (cl *cluster) forwardWrites(c *gin.Context) {
+	if isReadMethod(c.Request.Method) || cl.role() != roleFollower {
+		c.Next()
+		return
+	}
+	cl.forward(c, true)
+}
+
+// peersOnly lets through only requests from the cluster's instances. The
+// routes it guards do not exist on a standalone instance.
+func (cl *cluster) peersOnly(c *gin.Context) {
+	if cl.role() == roleStandalone {
+		render(c, http.StatusNotFound, errorResponse{
+			Error:   "not_found",
+			Message: "this instance is not part of a cluster",
+		})
+		c.Abort()
+		return
+	}
+	if !cl.isPeer[c.RemoteIP()] {
+		render(c, http.StatusForbidden, errorResponse{
+			Error:   "forbidden",
+			Message: "only cluster instances may call the cluster routes",
+		})
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// leaderOnly sends every request to the leader when this instance is a
+// follower, for state that only the leader keeps.
+func (cl *cluster) leaderOnly(c *gin.Context) {
+	if cl.role() != roleFollower {
+		c.Next()
+		return
+	}
+	cl.forward(c, false)
+}
+
+// forward proxies the request to the leader, catching up with it after
+// a successful write if sync is set.
+func (cl *cluster) forward(c *gin.Context, sync bool) {
+	target, _ := url.Parse(cl.leader)
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.SetXForwarded()
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			if !sync || resp.StatusCode >= 300 {
+				return nil
+			}
+			ctx, cancel := context.WithTimeout(resp.Request.Context(), forwardSyncTimeout)
+			defer cancel()
+			if err := cl.sync(ctx, 0); err != nil {
+				log.Printf("catch up after forwarded write: %v", err)
+			}
+			return nil
+		},
+		ErrorHandler: func(http.ResponseWriter, *http.Request, error) {
+			render(c, http.StatusBadGateway, errorResponse{
+				Error:   "leader_unavailable",
+				Message: fmt.Sprintf("could not forward the request to the cluster leader %s", cl.leader),
+			})
+		},
+	}
+	proxy.ServeHTTP(c.Writer, c.Request)
+	c.Abort()
+}
+
+// getChanges answers a follower's poll with the changes following the
+// after query parameter, holding the request open for up to wait when
+// there are none yet. A follower whose epoch does not match, or that is
+// too far behind, is answered 410 and must copy a snapshot.
+func (cl *cluster) getChanges(c *gin.Context) {
+	after, err := strconv.ParseUint(c.DefaultQuery("after", "0"), 10, 64)
+	if err != nil {
+		render(c, http.StatusBadRequest, errorResponse{
+			Error:   "invalid_request",
+			Message: "after must be a change index",
+		})
+		return
+	}
+	wait, err := time.ParseDuration(c.DefaultQuery("wait", "0s"))
+	if err != nil {
+		render(c, http.StatusBadRequest, errorResponse{
+			Error:   "invalid_request",
+			Message: "wait must be a duration such as 10s",
+		})
+		return
+	}
+
+	epoch, _ := cl.store.log.position()
+	if node := c.Query("node"); slices.Contains(cl.peers[1:], node) {
+		cl.mu.Lock()
+		cl.progress[node] = followerProgress{index: after, seen: time.Now()}
+		cl.mu.Unlock()
+	}
+
+	if c.Query("epoch") == epoch {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), min(wait, replicationPollWait))
+		defer cancel()
+		defer context.AfterFunc(cl.ctx, cancel)()
+		cl.store.log.wait(ctx, after)
+
+		changes, ok := cl.store.log.since(after, maxChangesPerPoll)
+		if ok {
+			_, last := cl.store.log.position()
+			render(c, http.StatusOK, changeBatch{Epoch: epoch, Index: last, Changes: changes})
+			return
+		}
+	}
+	render(c, http.StatusGone, errorResponse{
+		Error:   "resync_required",
+		Message: "changes after this position are not available; copy /cluster/snapshot",
+	})
+}
+
+// getSnapshot returns every album with the change log position it
+// reflects, for followers to start replicating from.
+func (cl *cluster) getSnapshot(c *gin.Context) {
+	snap, err := cl.store.snapshot()
+	if err != nil {
+		respondStorageError(c, err)
+		return
+	}
+	render(c, http.StatusOK, snap)
+}
+
+// clusterStatus is the response body for GET /cluster/status.
+type clusterStatus struct {
+	Node   string `json:"node,omitempty"`
+	Role   string `json:"role"`
+	Leader string `json:"leader,omitempty"`
+	Epoch  string `json:"epoch"`
+	Index  uint64 `json:"index"` // newest change applied here
+
+	// Replication is reported by followers.
+	Replication *replicationStatus `json:"replication,omitempty"`
+	// Followers is reported by the leader.
+	Followers []followerStatus `json:"followers,omitempty"`
+}
+
+// replicationStatus is how far a follower is behind the leader.
+type replicationStatus struct {
+	LeaderIndex uint64     `json:"leader_index"`
+	Lag         uint64     `json:"lag"` // changes not yet applied
+	LastContact *time.Time `json:"last_contact,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// followerStatus is the leader's view of one follower.
+type followerStatus struct {
+	Node     string     `json:"node"`
+	Index    uint64     `json:"index"`
+	Lag      uint64     `json:"lag"`
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+}
+
+// getStatus reports this instance's role and replication lag.
+func (cl *cluster) getStatus(c *gin.Context) {
+	epoch, index := cl.store.log.position()
+	st := clusterStatus{Node: cl.self, Role: cl.role(), Leader: cl.leader, Epoch: epoch, Index: index}
+
+	cl.mu.Lock()
+	switch st.Role {
+	case roleFollower:
+		r := &replicationStatus{LeaderIndex: cl.leaderIndex, Error: cl.lastErr}
+		if cl.leaderIndex > index {
+			r.Lag = cl.leaderIndex - index
+		}
+		if !cl.lastContact.IsZero() {
+			t := cl.lastContact.UTC()
+			r.LastContact = &t
+		}
+		st.Replication = r
+	case roleLeader:
+		for _, peer := range cl.peers[1:] {
+			f := followerStatus{Node: peer}
+			if p, ok := cl.progress[peer]; ok {
+				f.Index = p.index
+				f.Lag = index - min(p.index, index)
+				t := p.seen.UTC()
+				f.LastSeen = &t
+			} else {
+				f.Lag = cl.store.log.changes()
+			}
+			st.Followers = append(st.Followers, f)
+		}
+	}
+	cl.mu.Unlock()
+	render(c, http.StatusOK, st)
+}
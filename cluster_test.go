@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestCluster starts a leader and a follower replicating from it, each
+// served over loopback by a router built as main builds it.
+func newTestCluster(t *testing.T) (leader, follower *httptest.Server) {
+	t.Helper()
+	leader = httptest.NewUnstartedServer(nil)
+	follower = httptest.NewUnstartedServer(nil)
+	t.Cleanup(leader.Close)
+	t.Cleanup(follower.Close)
+
+	leaderURL := "http://" + leader.Listener.Addr().String()
+	followerURL := "http://" + follower.Listener.Addr().String()
+	for _, srv := range []struct {
+		*httptest.Server
+		self string
+	}{{leader, leaderURL}, {follower, followerURL}} {
+		cfg := testConfig()
+		cfg.ClusterPeers = leaderURL + "," + followerURL
+		cfg.ClusterSelf = srv.self
+		srv.Config.Handler = newTestApp(t, cfg, nil).router
+		srv.Start()
+	}
+	return leader, follower
+}
+
+// do sends a request to a test server and returns the response with its
+// body read.
+func do(t *testing.T, method, url, body string, header ...string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(b)
+}
+
+func TestFollowerForwardsWrites(t *testing.T) {
+	leader, follower := newTestCluster(t)
+
+	resp, body := do(t, http.MethodPost, follower.URL+"/albums", `{"id":"7","title":"Giant Steps","artist":"John Coltrane","price":"29.99"}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST to the follower: %s: %s", resp.Status, body)
+	}
+	tag := resp.Header.Get("ETag")
+
+	// The write was made on the leader, and the follower caught up with
+	// it before answering.
+	for _, srv := range []*httptest.Server{leader, follower} {
+		resp, body := do(t, http.MethodGet, srv.URL+"/albums/7", "")
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") != tag {
+			t.Errorf("GET from %s: %s with ETag %s, want %s: %s", srv.URL, resp.Status, resp.Header.Get("ETag"), tag, body)
+		}
+	}
+
+	resp, body = do(t, http.MethodPut, follower.URL+"/albums/7", `{"title":"Naima","artist":"John Coltrane","price":"9.99"}`, "If-Match", tag)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT to the follower: %s: %s", resp.Status, body)
+	}
+	if _, body := do(t, http.MethodGet, follower.URL+"/albums/7", ""); !strings.Contains(body, "Naima") {
+		t.Errorf("follower did not catch up with the PUT: %s", body)
+	}
+
+	// Rejected writes are answered by the leader too.
+	resp, body = do(t, http.MethodPut, follower.URL+"/albums/7", `{"title":"Naima","artist":"John Coltrane","price":"9.99"}`, "If-Match", tag)
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("stale PUT to the follower: %s: %s", resp.Status, body)
+	}
+}
+
+func TestClusterStatusNeedsAdmin(t *testing.T) {
+	cfg := testConfig()
+	cfg.AuthAPIKeys = "ops:admin:" + hashKey("admin-key") + ",ci:viewer:" + hashKey("viewer-key")
+	h := newTestApp(t, cfg, nil).router
+
+	wantError(t, send(h, http.MethodGet, "/cluster/status", ""), http.StatusUnauthorized, "unauthorized")
+	wantError(t, send(h, http.MethodGet, "/cluster/status", "", "X-API-Key", "viewer-key"), http.StatusForbidden, "forbidden")
+	w := send(h, http.MethodGet, "/cluster/status", "", "X-API-Key", "admin-key")
+	wantStatus(t, w, http.StatusOK)
+	if st := decode[clusterStatus](t, w); st.Role != roleStandalone {
+		t.Errorf("got role %s, want %s", st.Role, roleStandalone)
+	}
+
+	// The routes instances call on each other stay out of reach, whatever
+	// the credentials.
+	w = send(h, http.MethodGet, "/cluster/snapshot", "", "X-API-Key", "admin-key")
+	wantError(t, w, http.StatusNotFound, "not_found")
+}
+
+func TestClusterRoutesPeersOnly(t *testing.T) {
+	leader, _ := newTestCluster(t)
+
+	// Requests from the instances' own addresses get through.
+	if resp, body := do(t, http.MethodGet, leader.URL+"/cluster/snapshot", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /cluster/snapshot from a peer: %s: %s", resp.Status, body)
+	}
+
+	// Requests from other addresses are refused.
+	h := leader.Config.Handler
+	wantError(t, send(h, http.MethodGet, "/cluster/snapshot", ""), http.StatusForbidden, "forbidden")
+	wantError(t, send(h, http.MethodGet, "/cluster/changes", ""), http.StatusForbidden, "forbidden")
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-yaml"
+)
+
+// config holds the album server's settings.
+type config struct {
+	Addr    string
+	GinMode string
+	Storage string
+	DataDir string
+
+	// S3Bucket, S3Prefix and S3Endpoint locate the catalog for the s3
+	// storage backend. S3Endpoint is empty for AWS itself, or the URL of
+	// an S3-compatible server.
+	S3Bucket   string
+	S3Prefix   string
+	S3Endpoint string
+
+	// ClusterPeers lists the base URLs of every instance sharing the
+	// catalog, comma-separated, leader first. ClusterSelf is this
+	// instance's URL in that list.
+	ClusterPeers string
+	ClusterSelf  string
+
+	// AuthAPIKeys lists the accepted API keys, comma-separated, each as
+	// name:role:hash with the hex SHA-256 hash of the key.
+	AuthAPIKeys string
+
+	// AuthJWTSecret and AuthJWTPublicKey verify HS256 and RS256 bearer
+	// tokens; AuthJWTPublicKey is the path of a PEM file. Accepted
+	// tokens must come from AuthJWTIssuer and name AuthJWTAudience.
+	AuthJWTSecret    string
+	AuthJWTPublicKey string
+	AuthJWTIssuer    string
+	AuthJWTAudience  string
+
+	// AuthJWTRolesClaim names the token claim listing the caller's roles.
+	AuthJWTRolesClaim string
+
+	// AuthPublicReads lets requests that only need the viewer role
+	// through without credentials.
+	AuthPublicReads bool
+
+	// TrustedProxies lists the addresses, comma-separated IPs or CIDR
+	// ranges, of the proxies whose X-Forwarded-For header names the
+	// client. Cluster instances are always trusted.
+	TrustedProxies string
+
+	// RateLimitReads and RateLimitWrites are how many requests of each
+	// kind a client may make per second, in bursts of up to
+	// RateLimitReadBurst and RateLimitWriteBurst; zero means no limit.
+	// With RateLimitShared, followers count requests against the
+	// leader's budgets instead of their own.
+	RateLimitReads      float64
+	RateLimitReadBurst  int
+	RateLimitWrites     float64
+	RateLimitWriteBurst int
+	RateLimitShared     bool
+
+	// WebhookAllowPrivate lets webhooks deliver to loopback, private and
+	// link-local addresses, which are refused by default so that clients
+	// cannot reach internal services through them.
+	WebhookAllowPrivate bool
+
+	// LogLevel is the least severe level logged. AccessLogSample is the
+	// fraction of successful reads that get an access log entry.
+	LogLevel        string
+	AccessLogSample float64
+
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+
+	// ShutdownTimeout bounds how long in-flight requests may take to
+	// finish after SIGTERM before their connections are closed.
+	ShutdownTimeout time.Duration
+}
+
+var defaultConfig = config{
+	Addr:                ":8080",
+	GinMode:             gin.DebugMode,
+	Storage:             "memory",
+	DataDir:             "data",
+	S3Prefix:            "albums/",
+	AuthJWTRolesClaim:   "roles",
+	AuthPublicReads:     true,
+	RateLimitReads:      50,
+	RateLimitReadBurst:  100,
+	RateLimitWrites:     10,
+	RateLimitWriteBurst: 20,
+	LogLevel:            "info",
+	AccessLogSample:     1,
+	ReadTimeout:         15 * time.Second,
+	WriteTimeout:        30 * time.Second,
+	IdleTimeout:         2 * time.Minute,
+	ShutdownTimeout:     30 * time.Second,
+}
+
+// configField is one setting. It is read from the config file, an
+// environment variable and a flag, each overriding the one before.
+type configField struct {
+	flag  string // also the config file key, with "-" spelled "_"
+	env   string
+	usage string
+	def   any
+	set   func(string) error
+}
+
+// fields lists the settings of c, each setting its value in c.
+func (c *config) fields() []configField {
+	return []configField{
+		{"addr", "ALBUM_ADDR", "listen address", c.Addr, setString(&c.Addr)},
+		{"gin-mode", "GIN_MODE", "gin mode: debug, release or test", c.GinMode, setString(&c.GinMode)},
+		{"storage", "ALBUM_STORAGE", "album storage backend: memory, file or s3", c.Storage, setString(&c.Storage)},
+		{"data-dir", "ALBUM_DATA_DIR", "directory for the file storage backend, holding the albums and webhooks", c.DataDir, setString(&c.DataDir)},
+		{"s3-bucket", "ALBUM_S3_BUCKET", "bucket for the s3 storage backend", c.S3Bucket, setString(&c.S3Bucket)},
+		{"s3-prefix", "ALBUM_S3_PREFIX", "key prefix for the s3 storage backend", c.S3Prefix, setString(&c.S3Prefix)},
+		{"s3-endpoint", "ALBUM_S3_ENDPOINT", "URL of an S3-compatible server; empty for AWS", c.S3Endpoint, setString(&c.S3Endpoint)},
+		{"cluster-peers", "ALBUM_CLUSTER_PEERS", "comma-separated base URLs of all instances, leader first; empty to run standalone", c.ClusterPeers, setString(&c.ClusterPeers)},
+		{"cluster-self", "ALBUM_CLUSTER_SELF", "this instance's base URL as listed in cluster-peers", c.ClusterSelf, setString(&c.ClusterSelf)},
+		{"auth-api-keys", "ALBUM_AUTH_API_KEYS", "comma-separated name:role:sha256-hex API keys, role being viewer, editor or admin (hash a key with: printf %s KEY | sha256sum)", c.AuthAPIKeys, setString(&c.AuthAPIKeys)},
+		{"auth-jwt-secret", "ALBUM_AUTH_JWT_SECRET", "HS256 key for bearer tokens", "", setString(&c.AuthJWTSecret)},
+		{"auth-jwt-public-key", "ALBUM_AUTH_JWT_PUBLIC_KEY", "PEM file with the RS256 public key for bearer tokens", c.AuthJWTPublicKey, setString(&c.AuthJWTPublicKey)},
+		{"auth-jwt-issuer", "ALBUM_AUTH_JWT_ISSUER", "required iss claim of bearer tokens", c.AuthJWTIssuer, setString(&c.AuthJWTIssuer)},
+		{"auth-jwt-audience", "ALBUM_AUTH_JWT_AUDIENCE", "required aud claim of bearer tokens", c.AuthJWTAudience, setString(&c.AuthJWTAudience)},
+		{"auth-jwt-roles-claim", "ALBUM_AUTH_JWT_ROLES_CLAIM", "bearer token claim listing the caller's roles", c.AuthJWTRolesClaim, setString(&c.AuthJWTRolesClaim)},
+		{"auth-public-reads", "ALBUM_AUTH_PUBLIC_READS", "let requests that only need the viewer role through without credentials", c.AuthPublicReads, setBool(&c.AuthPublicReads)},
+		{"trusted-proxies", "ALBUM_TRUSTED_PROXIES", "comma-separated IPs or CIDR ranges of proxies whose X-Forwarded-For is believed", c.TrustedProxies, setString(&c.TrustedProxies)},
+		{"rate-limit-reads", "ALBUM_RATE_LIMIT_READS", "read requests per second per client; 0 for no limit", c.RateLimitReads, setFloat(&c.RateLimitReads)},
+		{"rate-limit-read-burst", "ALBUM_RATE_LIMIT_READ_BURST", "read requests a client may make at once", c.RateLimitReadBurst, setInt(&c.RateLimitReadBurst)},
+		{"rate-limit-writes", "ALBUM_RATE_LIMIT_WRITES", "write requests per second per client; 0 for no limit", c.RateLimitWrites, setFloat(&c.RateLimitWrites)},
+		{"rate-limit-write-burst", "ALBUM_RATE_LIMIT_WRITE_BURST", "write requests a client may make at once", c.RateLimitWriteBurst, setInt(&c.RateLimitWriteBurst)},
+		{"rate-limit-shared", "ALBUM_RATE_LIMIT_SHARED", "count every instance's requests against the cluster leader's rate limits", c.RateLimitShared, setBool(&c.RateLimitShared)},
+		{"webhook-allow-private", "ALBUM_WEBHOOK_ALLOW_PRIVATE", "let webhooks deliver to loopback, private and link-local addresses", c.WebhookAllowPrivate, setBool(&c.WebhookAllowPrivate)},
+		{"log-level", "ALBUM_LOG_LEVEL", "least severe level logged: debug, info, warn or error", c.LogLevel, setString(&c.LogLevel)},
+		{"access-log-sample", "ALBUM_ACCESS_LOG_SAMPLE", "fraction of successful reads given an access log entry, from 0 to 1", c.AccessLogSample, setFloat(&c.AccessLogSample)},
+		{"read-timeout", "ALBUM_READ_TIMEOUT", "maximum time to read a request", c.ReadTimeout, setDuration(&c.ReadTimeout)},
+		{"write-timeout", "ALBUM_WRITE_TIMEOUT", "maximum time to write a response", c.WriteTimeout, setDuration(&c.WriteTimeout)},
+		{"idle-timeout", "ALBUM_IDLE_TIMEOUT", "how long idle keep-alive connections are kept open", c.IdleTimeout, setDuration(&c.IdleTimeout)},
+		{"shutdown-timeout", "ALBUM_SHUTDOWN_TIMEOUT", "how long to drain in-flight requests on shutdown", c.ShutdownTimeout, setDuration(&c.ShutdownTimeout)},
+	}
+}
+
+func setString(dst *string) func(string) error {
+	return func(v string) error {
+		*dst = v
+		return nil
+	}
+}
+
+func setBool(dst *bool) func(string) error {
+	return func(v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*dst = b
+		return nil
+	}
+}
+
+func setInt(dst *int) func(string) error {
+	return func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return errors.New("must not be negative")
+		}
+		*dst = n
+		return nil
+	}
+}
+
+func setFloat(dst *float64) func(string) error {
+	return func(v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return err
+		}
+		if f < 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+			return errors.New("must be a non-negative number")
+		}
+		*dst = f
+		return nil
+	}
+}
+
+func setDuration(dst *time.Duration) func(string) error {
+	return func(v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		if d < 0 {
+			return errors.New("must not be negative")
+		}
+		*dst = d
+		return nil
+	}
+}
+
+// loadConfig builds the configuration from defaultConfig, the optional
+// YAML config file named by -config or ALBUM_CONFIG, environment
+// variables and command-line flags, in increasing order of precedence.
+func loadConfig(args []string) (config, error) {
+	cfg := defaultConfig
+	fields := cfg.fields()
+
+	fs := flag.NewFlagSet("album-server", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv("ALBUM_CONFIG"), "optional YAML config file (env ALBUM_CONFIG)")
+	flagged := make(map[string]string)
+	for _, f := range fields {
+		usage := fmt.Sprintf("%s (default %v, env %s)", f.usage, f.def, f.env)
+		set := func(v string) error {
+			flagged[f.flag] = v
+			return nil
+		}
+		if _, ok := f.def.(bool); ok {
+			fs.BoolFunc(f.flag, usage, set)
+		} else {
+			fs.Func(f.flag, usage, set)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if *path != "" {
+		if err := loadConfigFile(*path, fields); err != nil {
+			return cfg, fmt.Errorf("config file %s: %w", *path, err)
+		}
+	}
+	for _, f := range fields {
+		if v, ok := os.LookupEnv(f.env); ok {
+			if err := f.set(v); err != nil {
+				return cfg, fmt.Errorf("%s: %w", f.env, err)
+			}
+		}
+		if v, ok := flagged[f.flag]; ok {
+			if err := f.set(v); err != nil {
+				return cfg, fmt.Errorf("-%s: %w", f.flag, err)
+			}
+		}
+	}
+
+	switch cfg.GinMode {
+	case gin.DebugMode, gin.ReleaseMode, gin.TestMode:
+	default:
+		return cfg, fmt.Errorf("unknown gin mode %q (want debug, release or test)", cfg.GinMode)
+	}
+	if cfg.Storage == "s3" && cfg.ClusterPeers != "" {
+		// Every instance already reads and writes the same bucket.
+		return cfg, errors.New("the s3 storage backend cannot be combined with cluster-peers")
+	}
+	if cfg.AccessLogSample > 1 {
+		return cfg, errors.New("access-log-sample must be at most 1")
+	}
+	if cfg.RateLimitShared && cfg.ClusterPeers == "" {
+		return cfg, errors.New("rate-limit-shared needs cluster-peers")
+	}
+	return cfg, nil
+}
+
+// loadConfigFile applies the settings in a YAML file such as
+//
+//	addr: ":8080"
+//	storage: file
+//	write_timeout: 1m
+//	auth_api_keys:
+//	  - ci:editor:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+func loadConfigFile(path string, fields []configField) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var values map[string]any
+	if err := yaml.Unmarshal(b, &values); err != nil {
+		return err
+	}
+
+	byKey := make(map[string]configField, len(fields))
+	for _, f := range fields {
+		byKey[strings.ReplaceAll(f.flag, "-", "_")] = f
+	}
+	for key, v := range values {
+		f, ok := byKey[key]
+		if !ok {
+			return fmt.Errorf("unknown setting %q", key)
+		}
+		if list, ok := v.([]any); ok {
+			// Lists are the YAML spelling of comma-separated settings.
+			items := make([]string, len(list))
+			for i, item := range list {
+				items[i] = fmt.Sprint(item)
+			}
+			v = strings.Join(items, ",")
+		}
+		if err := f.set(fmt.Sprint(v)); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return nil
+}
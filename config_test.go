@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "album.yaml")
+	file := `addr: ":9000"
+storage: file
+data_dir: /var/lib/albums
+rate_limit_reads: 5
+read_timeout: 5s
+cluster_peers:
+  - http://a:8080
+  - http://b:8080
+`
+	if err := os.WriteFile(path, []byte(file), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ALBUM_CONFIG", path)
+	t.Setenv("ALBUM_DATA_DIR", "/srv/albums")
+	t.Setenv("ALBUM_RATE_LIMIT_READS", "7")
+
+	cfg, err := loadConfig([]string{"-rate-limit-reads", "9", "-auth-public-reads=false"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks := []struct {
+		name      string
+		got, want any
+	}{
+		{"default", cfg.LogLevel, "info"},
+		{"file", cfg.Addr, ":9000"},
+		{"file", cfg.Storage, "file"},
+		{"file list", cfg.ClusterPeers, "http://a:8080,http://b:8080"},
+		{"file duration", cfg.ReadTimeout, 5 * time.Second},
+		{"env over file", cfg.DataDir, "/srv/albums"},
+		{"flag over env", cfg.RateLimitReads, 9.0},
+		{"bool flag", cfg.AuthPublicReads, false},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, c.got, c.want)
+		}
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "album.yaml")
+	if err := os.WriteFile(path, []byte("adress: \":9000\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{"unknown file setting", map[string]string{"ALBUM_CONFIG": path}, nil, `unknown setting "adress"`},
+		{"bad env value", map[string]string{"ALBUM_RATE_LIMIT_READS": "lots"}, nil, "ALBUM_RATE_LIMIT_READS"},
+		{"bad flag value", nil, []string{"-read-timeout", "soon"}, "-read-timeout"},
+		{"bad gin mode", nil, []string{"-gin-mode", "fast"}, "unknown gin mode"},
+		{"s3 with peers", nil, []string{"-storage", "s3", "-cluster-peers", "http://a:8080"}, "cannot be combined"},
+		{"shared limits alone", nil, []string{"-rate-limit-shared"}, "needs cluster-peers"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			_, err := loadConfig(tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want one mentioning %s", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// etag returns the strong entity tag for a as rendered in the response
+// type negotiated for c. Besides a's revision it names the type, since
+// each renders a differently.
+func etag(c *gin.Context, a album) string {
+	return fmt.Sprintf(`"%d-%d-%s"`, a.Seq, a.Version, formatName(c.GetString(formatKey)))
+}
+
+// setETag sets the ETag response header for a.
+func setETag(c *gin.Context, a album) {
+	c.Header("ETag", etag(c, a))
+}
+
+// notModified reports whether the request's If-None-Match header matches
+// a, in which case it writes a 304 response.
+func notModified(c *gin.Context, a album) bool {
+	header := c.GetHeader("If-None-Match")
+	if header == "" {
+		return false
+	}
+	current := etag(c, a)
+	for _, tag := range strings.Split(header, ",") {
+		// If-None-Match uses the weak comparison function.
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == current {
+			setETag(c, a)
+			c.Status(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// parseIfMatch reads the If-Match header required on album writes and
+// returns the revisions it allows, or anyRevision for "*". The response
+// type a tag names is ignored, as the tag of any representation is as
+// good a record of what the client last read. Tags that are weak or not
+// album ETags can never match and are dropped. A missing header is
+// answered with 428 and false.
+func parseIfMatch(c *gin.Context) ([]revision, bool) {
+	header := c.GetHeader("If-Match")
+	if strings.TrimSpace(header) == "" {
+		render(c, http.StatusPreconditionRequired, errorResponse{
+			Error:   "precondition_required",
+			Message: "If-Match header is required; send the ETag from a previous GET",
+		})
+		return nil, false
+	}
+
+	var revs []revision
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return []revision{anyRevision}, true
+		}
+		unquoted, err := strconv.Unquote(tag)
+		if err != nil || !strings.HasPrefix(tag, `"`) {
+			continue
+		}
+		if r, ok := parseRevision(unquoted); ok {
+			revs = append(revs, r)
+		}
+	}
+	return revs, true
+}
+
+// parseRevision reads the revision from an unquoted album ETag.
+func parseRevision(tag string) (revision, bool) {
+	parts := strings.SplitN(tag, "-", 3)
+	if len(parts) != 3 {
+		return revision{}, false
+	}
+	seq, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil || seq == 0 {
+		return revision{}, false
+	}
+	v, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || v <= 0 {
+		return revision{}, false
+	}
+	return revision{Seq: seq, Version: v}, true
+}
+
+// ifMatchAllows reports whether revisions from parseIfMatch allow a
+// write to a.
+func ifMatchAllows(revs []revision, a album) bool {
+	return slices.ContainsFunc(revs, func(r revision) bool { return r.matches(a) })
+}
+
+// withIfMatch runs a conditional write once per revision the If-Match
+// header allows, stopping at the first attempt not rejected with
+// errVersionMismatch.
+func withIfMatch(revs []revision, write func(ifRev revision) error) error {
+	err := errVersionMismatch
+	for _, r := range revs {
+		if err = write(r); !errors.Is(err, errVersionMismatch) {
+			return err
+		}
+	}
+	return err
+}
+
+// respondPreconditionFailed reports that an album changed since the
+// client last read it.
+func respondPreconditionFailed(c *gin.Context, id string) {
+	render(c, http.StatusPreconditionFailed, errorResponse{
+		Error:   "precondition_failed",
+		Message: fmt.Sprintf("album with ID '%s' has changed; fetch it again and retry with its current ETag", id),
+	})
+}
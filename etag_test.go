@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestGetAlbumConditional(t *testing.T) {
+	h := newTestApp(t, testConfig(), nil).router
+
+	w := send(h, http.MethodGet, "/albums/1", "")
+	wantStatus(t, w, http.StatusOK)
+	tag := w.Header().Get("ETag")
+	if tag != `"1-1-json"` {
+		t.Errorf("got ETag %s, want \"1-1-json\"", tag)
+	}
+	if v := w.Header().Get("Vary"); v != "Accept" {
+		t.Errorf("got Vary %q, want Accept", v)
+	}
+
+	w = send(h, http.MethodGet, "/albums/1", "", "If-None-Match", tag)
+	wantStatus(t, w, http.StatusNotModified)
+	if w.Body.Len() != 0 || w.Header().Get("ETag") != tag {
+		t.Errorf("304 with ETag %s and body %q", w.Header().Get("ETag"), w.Body)
+	}
+
+	// Another representation of the same album has a tag of its own.
+	w = send(h, http.MethodGet, "/albums/1", "", "If-None-Match", tag, "Accept", "application/xml")
+	wantStatus(t, w, http.StatusOK)
+	if xmlTag := w.Header().Get("ETag"); xmlTag == tag {
+		t.Errorf("XML and JSON share the ETag %s", tag)
+	}
+
+	// A write changes the tag.
+	w = send(h, http.MethodPatch, "/albums/1", `{"price":"49.99"}`, "Content-Type", mergePatchType, "If-Match", tag)
+	wantStatus(t, w, http.StatusOK)
+	w = send(h, http.MethodGet, "/albums/1", "", "If-None-Match", tag)
+	wantStatus(t, w, http.StatusOK)
+}
+
+func TestWriteStaleIfMatch(t *testing.T) {
+	h := newTestApp(t, testConfig(), nil).router
+	const body = `{"title":"Blue Train","artist":"John Coltrane","price":"19.99"}`
+
+	tag := send(h, http.MethodGet, "/albums/1", "").Header().Get("ETag")
+	wantStatus(t, send(h, http.MethodPut, "/albums/1", body, "If-Match", tag), http.StatusOK)
+
+	tests := []struct {
+		method      string
+		body        string
+		contentType string
+	}{
+		{http.MethodPut, body, "application/json"},
+		{http.MethodPatch, `{"price":"9.99"}`, mergePatchType},
+		{http.MethodDelete, "", ""},
+	}
+	for _, tt := range tests {
+		w := send(h, tt.method, "/albums/1", tt.body, "Content-Type", tt.contentType, "If-Match", tag)
+		wantError(t, w, http.StatusPreconditionFailed, "precondition_failed")
+	}
+}
+
+func TestIfMatchIgnoresResponseType(t *testing.T) {
+	h := newTestApp(t, testConfig(), nil).router
+
+	tag := send(h, http.MethodGet, "/albums/1", "", "Accept", "text/csv").Header().Get("ETag")
+	w := send(h, http.MethodPut, "/albums/1", `{"title":"Blue Train","artist":"John Coltrane","price":"19.99"}`, "If-Match", tag)
+	wantStatus(t, w, http.StatusOK)
+}
+
+func TestIfMatchAfterRecreate(t *testing.T) {
+	h := newTestApp(t, testConfig(), nil).router
+	const body = `{"id":"7","title":"Giant Steps","artist":"John Coltrane","price":"29.99"}`
+
+	w := send(h, http.MethodPost, "/albums", body)
+	wantStatus(t, w, http.StatusCreated)
+	stale := w.Header().Get("ETag")
+	wantStatus(t, send(h, http.MethodDelete, "/albums/7?hard=true", "", "If-Match", stale), http.StatusNoContent)
+
+	// The new album has the same ID and Version as the purged one.
+	w = send(h, http.MethodPost, "/albums", body)
+	wantStatus(t, w, http.StatusCreated)
+	if a := decode[album](t, w); a.Version != 1 {
+		t.Fatalf("re-created album has version %d, want 1", a.Version)
+	}
+	if w.Header().Get("ETag") == stale {
+		t.Fatalf("re-created album has the purged album's ETag %s", stale)
+	}
+
+	w = send(h, http.MethodPut, "/albums/7", `{"title":"Naima","artist":"John Coltrane","price":"9.99"}`, "If-Match", stale)
+	wantError(t, w, http.StatusPreconditionFailed, "precondition_failed")
+	wantError(t, send(h, http.MethodDelete, "/albums/7", "", "If-Match", stale), http.StatusPreconditionFailed, "precondition_failed")
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// feedHeartbeat is how often an idle change feed sends a comment, so
+	// that proxies do not close the connection.
+	feedHeartbeat = 15 * time.Second
+
+	// feedBatch caps the events written between flushes.
+	feedBatch = 500
+)
+
+// Change feed event types.
+const (
+	eventCreated = "created"
+	eventUpdated = "updated"
+	eventDeleted = "deleted"
+	eventReset   = "reset"
+)
+
+// purgedAlbum is the data of the event reporting a purged album.
+type purgedAlbum struct {
+	ID     string `json:"id"`
+	Purged bool   `json:"purged"`
+}
+
+// feedReset is the data of a reset event.
+type feedReset struct {
+	Message string `json:"message"`
+}
+
+// albumFeed streams the changes recorded in a changeLog to clients as
+// Server-Sent Events.
+type albumFeed struct {
+	log   *changeLog
+	ctx   context.Context // canceled on shutdown
+	close context.CancelFunc
+}
+
+func newAlbumFeed(log *changeLog) *albumFeed {
+	f := &albumFeed{log: log}
+	f.ctx, f.close = context.WithCancel(context.Background())
+	return f
+}
+
+// changeEvent returns the type of the event reporting c, and the data it
+// carries: the album as stored by the change, or only its ID once purged.
+func changeEvent(c change) (string, any) {
+	if c.Op == "purge" || c.Album == nil {
+		return eventDeleted, purgedAlbum{ID: c.ID, Purged: true}
+	}
+	a := c.Album.album
+	switch {
+	case a.DeletedAt != nil:
+		return eventDeleted, a
+	case a.Version == 1:
+		return eventCreated, a
+	}
+	return eventUpdated, a
+}
+
+// feedEvent returns the change feed event reporting c.
+func feedEvent(c change) sse.Event {
+	event, data := changeEvent(c)
+	return sse.Event{Id: strconv.FormatUint(c.Index, 10), Event: event, Data: data}
+}
+
+// streamEvents streams album changes as they happen. Each "created",
+// "updated" or "deleted" event carries the data given by changeEvent and
+// is identified by the change's index, which increases with every change.
+// A client reconnecting with Last-Event-ID first gets the events it
+// missed. When those are no longer held, it gets a "reset" event instead,
+// telling it to reload the catalog.
+func (f *albumFeed) streamEvents(c *gin.Context) {
+	_, after := f.log.position()
+	if v := c.GetHeader("Last-Event-ID"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			render(c, http.StatusBadRequest, errorResponse{
+				Error:   "invalid_request",
+				Message: "Last-Event-ID must be the ID of an event from this feed",
+			})
+			return
+		}
+		after = id
+	}
+
+	// The stream outlasts the server's write timeout; it ends when the
+	// client goes away or the server shuts down.
+	http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	defer context.AfterFunc(f.ctx, cancel)()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	for ctx.Err() == nil {
+		changes, ok := f.log.since(after, feedBatch)
+		if !ok {
+			_, after = f.log.position()
+			c.Render(-1, sse.Event{
+				Id:    strconv.FormatUint(after, 10),
+				Event: eventReset,
+				Data:  feedReset{Message: "missed changes are no longer available; reload the catalog"},
+			})
+		}
+		for _, ch := range changes {
+			c.Render(-1, feedEvent(ch))
+			after = ch.Index
+		}
+		c.Writer.Flush()
+		if len(changes) == feedBatch {
+			continue
+		}
+
+		wait, stop := context.WithTimeout(ctx, feedHeartbeat)
+		f.log.wait(wait, after)
+		stop()
+		if _, last := f.log.position(); last == after && ctx.Err() == nil {
+			c.Writer.WriteString(": keep-alive\n\n")
+			c.Writer.Flush()
+		}
+	}
+}
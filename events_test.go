@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// sseEvent is one event read from a change feed.
+type sseEvent struct {
+	id, event, data string
+}
+
+// openFeed connects to the change feed at url, resuming after lastID if
+// it is set, and returns a function reading its next event.
+func openFeed(t *testing.T, url, lastID string) (next func() sseEvent, close func()) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/albums/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /albums/events: %s", resp.Status)
+	}
+	r := bufio.NewReader(resp.Body)
+	next = func() sseEvent {
+		t.Helper()
+		var e sseEvent
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("read event: %v", err)
+			}
+			line = strings.TrimRight(line, "\n")
+			if line == "" && e.event != "" {
+				return e
+			}
+			field, value, _ := strings.Cut(line, ":")
+			switch field {
+			case "id":
+				e.id = value
+			case "event":
+				e.event = value
+			case "data":
+				e.data = value
+			}
+		}
+	}
+	return next, func() {
+		cancel()
+		resp.Body.Close()
+	}
+}
+
+// eventIndex returns the change index an event is identified by.
+func eventIndex(t *testing.T, e sseEvent) uint64 {
+	t.Helper()
+	i, err := strconv.ParseUint(e.id, 10, 64)
+	if err != nil {
+		t.Fatalf("event %+v: %v", e, err)
+	}
+	return i
+}
+
+func TestStreamEventsResume(t *testing.T) {
+	a := newTestApp(t, testConfig(), nil)
+	srv := httptest.NewServer(a.router)
+	t.Cleanup(srv.Close)
+	t.Cleanup(a.feed.close) // before srv.Close, which waits for streams to end
+
+	next, stop := openFeed(t, srv.URL, "")
+	wantStatus(t, send(a.router, http.MethodPost, "/albums", `{"id":"7","title":"Giant Steps","artist":"John Coltrane","price":"29.99"}`), http.StatusCreated)
+	first := next()
+	if first.event != eventCreated || !strings.Contains(first.data, `"id":"7"`) {
+		t.Fatalf("got %+v, want album 7 created", first)
+	}
+	stop()
+
+	// Changes made while disconnected are sent on reconnecting.
+	wantStatus(t, send(a.router, http.MethodPatch, "/albums/7", `{"price":"9.99"}`, "Content-Type", mergePatchType, "If-Match", "*"), http.StatusOK)
+	wantStatus(t, send(a.router, http.MethodDelete, "/albums/2?hard=true", "", "If-Match", "*"), http.StatusNoContent)
+
+	next, stop = openFeed(t, srv.URL, first.id)
+	defer stop()
+	for _, want := range []struct{ event, data string }{
+		{eventUpdated, `"price":9.99`},
+		{eventDeleted, `"purged":true`},
+	} {
+		e := next()
+		if e.event != want.event || !strings.Contains(e.data, want.data) || eventIndex(t, e) <= eventIndex(t, first) {
+			t.Errorf("got %+v, want a %s event with %s", e, want.event, want.data)
+		}
+	}
+}
+
+func TestStreamEventsReset(t *testing.T) {
+	a := newTestApp(t, testConfig(), nil)
+	srv := httptest.NewServer(a.router)
+	t.Cleanup(srv.Close)
+	t.Cleanup(a.feed.close) // before srv.Close, which waits for streams to end
+
+	// An ID from before the log begins can no longer be resumed from.
+	next, stop := openFeed(t, srv.URL, "1")
+	defer stop()
+	if e := next(); e.event != eventReset {
+		t.Errorf("got %+v, want a reset event", e)
+	}
+}
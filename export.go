@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportPageSize is how many albums an export reads from the store at a
+// time. Each page is flushed once written, so long exports reach the
+// client as they are produced.
+const exportPageSize = 500
+
+// albumCSVHeader is the CSV column order, matching the album JSON fields.
+var albumCSVHeader = []string{"id", "title", "artist", "price", "currency", "version"}
+
+// albumCSVRecord returns a's values in albumCSVHeader order.
+func albumCSVRecord(a album) []string {
+	return []string{
+		a.ID,
+		a.Title,
+		a.Artist,
+		a.Price.String(),
+		a.Currency,
+		strconv.FormatInt(a.Version, 10),
+	}
+}
+
+// exportAlbums streams every live album as NDJSON or CSV, chosen by the
+// format query parameter. Albums are read from the store a page at a time
+// by following a listing cursor, and encoded and flushed as they are
+// written, so the export never holds the whole catalog in memory.
+func (s *server) exportAlbums(c *gin.Context) {
+	format := c.DefaultQuery("format", "ndjson")
+	if format != "ndjson" && format != "csv" {
+		render(c, http.StatusBadRequest, errorResponse{
+			Error:   "invalid_format",
+			Message: "format must be ndjson or csv",
+		})
+		return
+	}
+
+	// Read the first page before answering, so that a failing store
+	// still gets an error response.
+	var after pageCursor
+	page, err := s.store.ListAfter(after.Seq, exportPageSize)
+	if err != nil {
+		respondStorageError(c, err)
+		return
+	}
+
+	// A large export may outlast the server's write timeout; it stops
+	// instead when the client goes away.
+	http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="albums.%s"`, format))
+	w := bufio.NewWriter(c.Writer)
+
+	var write func(a album)
+	flushRecords := func() {}
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		cw := csv.NewWriter(w)
+		cw.Write(albumCSVHeader)
+		write = func(a album) { cw.Write(albumCSVRecord(a)) }
+		flushRecords = cw.Flush
+	} else {
+		c.Header("Content-Type", ndjsonType)
+		enc := json.NewEncoder(w)
+		write = func(a album) { enc.Encode(a) }
+	}
+	c.Status(http.StatusOK)
+
+	for {
+		for _, a := range page {
+			write(a)
+		}
+		flushRecords()
+		// Push the page to the client, stopping once it has gone away.
+		if w.Flush() != nil {
+			return
+		}
+		c.Writer.Flush()
+		if c.Request.Context().Err() != nil || len(page) < exportPageSize {
+			return
+		}
+
+		after = cursorAfter(page[len(page)-1], albumQuery{})
+		if page, err = s.store.ListAfter(after.Seq, exportPageSize); err != nil {
+			// The status is already sent, so the failure can only be
+			// signalled by cutting the response off.
+			slog.Error("export failed", "request_id", c.GetString(requestIDKey), "error", err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	snapshotFile = "albums.snapshot.json"
+	logFile      = "albums.log"
+
+	// snapshotEvery is how many logged writes trigger a compaction.
+	snapshotEvery = 1000
+)
+
+// snapshotAlbum is an album as recorded in the snapshot, which unlike
+// the log keeps each album's Seq so listing order survives compaction.
+type snapshotAlbum struct {
+	album
+	Seq uint64 `json:"seq"`
+}
+
+// fileSnapshot is the content of the snapshot file. Seq is the highest
+// Seq assigned so far, which outlives the album it went to once that is
+// purged, so that it is never assigned again: a cursor past it would skip
+// the album getting it. Starting from Seq, replaying the log assigns new
+// albums the same Seqs they had originally. Snapshots written before Seq
+// was recorded are a bare array of albums.
+type fileSnapshot struct {
+	Seq    uint64          `json:"seq"`
+	Albums []snapshotAlbum `json:"albums"`
+}
+
+// logEntry is one line of the append-only album log. A "batch" entry
+// puts several albums, so that a crash cannot leave only some of them.
+type logEntry struct {
+	Op     string  `json:"op"` // "put", "batch" or "delete"
+	Album  *album  `json:"album,omitempty"`
+	Albums []album `json:"albums,omitempty"`
+	ID     string  `json:"id,omitempty"`
+}
+
+// fileStore is an AlbumStore persisted to a directory as a compacted
+// snapshot plus an append-only log of the writes made since. Reads are
+// served from memory; every write is fsynced to the log before it is
+// applied.
+type fileStore struct {
+	mem *memoryStore
+
+	mu      sync.Mutex // serializes writes and compaction
+	dir     string
+	log     *os.File
+	pending int // writes logged since the last snapshot
+}
+
+// openFileStore loads the catalog stored in dir, creating dir and seeding
+// it with seed if it holds no data yet.
+func openFileStore(dir string, seed []album) (*fileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &fileStore{mem: newMemoryStore(nil), dir: dir}
+
+	fresh, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	if fresh {
+		for _, a := range seed {
+			s.mem.put(a)
+		}
+	}
+
+	// Start every run from a fresh snapshot and an empty log.
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load replays the snapshot and log into memory. It reports whether
+// neither file existed.
+func (s *fileStore) load() (fresh bool, err error) {
+	snap, err := os.ReadFile(filepath.Join(s.dir, snapshotFile))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		fresh = true
+	case err != nil:
+		return false, err
+	default:
+		var fs fileSnapshot
+		if bytes.HasPrefix(bytes.TrimSpace(snap), []byte("[")) {
+			err = json.Unmarshal(snap, &fs.Albums)
+		} else {
+			err = json.Unmarshal(snap, &fs)
+		}
+		if err != nil {
+			return false, fmt.Errorf("read %s: %w", snapshotFile, err)
+		}
+		for _, a := range fs.Albums {
+			a.album.Seq = a.Seq
+			upgradeLegacyPrice(&a.album)
+			s.mem.put(a.album)
+		}
+		s.mem.skipSeq(fs.Seq)
+	}
+
+	f, err := os.OpenFile(filepath.Join(s.dir, logFile), os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return fresh, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	return false, s.replay(f)
+}
+
+// replay applies every complete entry in the log. A torn final line left
+// by a crash mid-write is truncated away.
+func (s *fileStore) replay(f *os.File) error {
+	r := bufio.NewReader(f)
+	var offset int64
+	for line := 1; ; line++ {
+		b, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(b)) > 0 {
+				return f.Truncate(offset)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var e logEntry
+		if err := json.Unmarshal(b, &e); err != nil {
+			return fmt.Errorf("read %s line %d: %w", logFile, line, err)
+		}
+		switch {
+		case e.Op == "put" && e.Album != nil:
+			upgradeLegacyPrice(e.Album)
+			s.mem.put(*e.Album)
+		case e.Op == "batch":
+			for i := range e.Albums {
+				upgradeLegacyPrice(&e.Albums[i])
+			}
+			s.mem.putAll(e.Albums)
+		case e.Op == "delete":
+			s.mem.drop(e.ID)
+		default:
+			return fmt.Errorf("read %s line %d: unknown op %q", logFile, line, e.Op)
+		}
+		offset += int64(len(b))
+	}
+}
+
+// append durably records e in the log, compacting when the log has grown
+// past snapshotEvery entries. The caller must hold s.mu.
+func (s *fileStore) append(e logEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := s.log.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if err := s.log.Sync(); err != nil {
+		return err
+	}
+	s.pending++
+	return nil
+}
+
+// compact writes the in-memory catalog to a new snapshot and starts an
+// empty log. The caller must hold s.mu or have exclusive access.
+func (s *fileStore) compact() error {
+	all := s.mem.all()
+	snap := fileSnapshot{Seq: s.mem.lastSeq(), Albums: make([]snapshotAlbum, len(all))}
+	for i, a := range all {
+		snap.Albums[i] = snapshotAlbum{album: a, Seq: a.Seq}
+	}
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	if err := writeFileSync(filepath.Join(s.dir, snapshotFile), b, 0o644); err != nil {
+		return err
+	}
+
+	if s.log != nil {
+		s.log.Close()
+	}
+	s.log, err = os.OpenFile(filepath.Join(s.dir, logFile), os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s.pending = 0
+	return syncDir(s.dir)
+}
+
+// maybeCompact compacts once enough writes have been logged. A failed
+// compaction is not fatal since the log still holds every write.
+func (s *fileStore) maybeCompact() {
+	if s.pending < snapshotEvery {
+		return
+	}
+	if err := s.compact(); err != nil {
+		slog.Error("album snapshot failed", "error", err)
+	}
+}
+
+// writeFileSync atomically replaces name with data, in a file created
+// with permissions perm.
+func writeFileSync(name string, data []byte, perm os.FileMode) error {
+	tmp := name + ".tmp"
+	os.Remove(tmp) // left by a crash, perhaps with other permissions
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(name))
+}
+
+// syncDir fsyncs a directory so renames and creates within it are durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (s *fileStore) Get(id string) (album, error) {
+	return s.mem.Get(id)
+}
+
+func (s *fileStore) List() ([]album, error) {
+	return s.mem.List()
+}
+
+func (s *fileStore) ListAfter(after uint64, limit int) ([]album, error) {
+	return s.mem.ListAfter(after, limit)
+}
+
+func (s *fileStore) Trash() ([]album, error) {
+	return s.mem.Trash()
+}
+
+func (s *fileStore) Create(a album) (album, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.mem.lookup(a.ID)
+	a, err := createTransition(cur, ok, a)
+	if err != nil {
+		return album{}, err
+	}
+	return s.put(a)
+}
+
+func (s *fileStore) CreateBatch(albums []album) ([]album, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	albums, err := createBatchTransition(albums, s.mem.lookup)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.append(logEntry{Op: "batch", Albums: albums}); err != nil {
+		return nil, err
+	}
+	albums = s.mem.putAll(albums)
+	s.maybeCompact()
+	return albums, nil
+}
+
+func (s *fileStore) Update(a album, ifRev revision) (album, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.mem.lookup(a.ID)
+	a, err := updateTransition(cur, ok, a, ifRev)
+	if err != nil {
+		return album{}, err
+	}
+	return s.put(a)
+}
+
+func (s *fileStore) Delete(id string, ifRev revision) (album, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.mem.lookup(id)
+	a, err := deleteTransition(cur, ok, ifRev)
+	if err != nil {
+		return album{}, err
+	}
+	return s.put(a)
+}
+
+func (s *fileStore) Restore(id string) (album, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.mem.lookup(id)
+	a, err := restoreTransition(cur, ok)
+	if err != nil {
+		return album{}, err
+	}
+	return s.put(a)
+}
+
+func (s *fileStore) Purge(id string, ifRev revision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.mem.lookup(id)
+	if err := purgeCheck(cur, ok, ifRev); err != nil {
+		return err
+	}
+	return s.drop(id)
+}
+
+func (s *fileStore) apply(a album) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.put(a)
+	return err
+}
+
+func (s *fileStore) discard(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.drop(id)
+}
+
+// reset replaces the catalog and writes it out as a fresh snapshot.
+func (s *fileStore) reset(albums []album) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mem.reset(albums)
+	return s.compact()
+}
+
+// drop logs the removal of an album and then applies it. The caller must
+// hold s.mu.
+func (s *fileStore) drop(id string) error {
+	if err := s.append(logEntry{Op: "delete", ID: id}); err != nil {
+		return err
+	}
+	s.mem.drop(id)
+	s.maybeCompact()
+	return nil
+}
+
+// put logs a as the new state of its album and then applies it,
+// returning the album as stored. The caller must hold s.mu.
+func (s *fileStore) put(a album) (album, error) {
+	if err := s.append(logEntry{Op: "put", Album: &a}); err != nil {
+		return album{}, err
+	}
+	a = s.mem.put(a)
+	s.maybeCompact()
+	return a, nil
+}
+
+// Close writes a final snapshot and releases the log file.
+func (s *fileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.compact(); err != nil {
+		return err
+	}
+	return s.log.Close()
+}
@@ -1,4 +1,3 @@
-import time
 from locust import task, between
 from locust.contrib.fasthttp import FastHttpUser
 
@@ -12,7 +11,6 @@ class AlbumsUser(FastHttpUser):
     @task(1)
     def post_album(self):
         payload = {
-            "id": str(time.time_ns()),
             "title": "Load Test Album",
             "artist": "Locust",
             "price": 10.00
@@ -1,4 +1,3 @@
-import time
 from locust import HttpUser, task, between
 
 class AlbumsUser(HttpUser):
@@ -13,7 +12,6 @@ class AlbumsUser(HttpUser):
     @task(1)
     def post_album(self):
         payload = {
-            "id": str(time.time_ns()),
             "title": "Load Test Album",
             "artist": "Locust",
             "price": 10.00
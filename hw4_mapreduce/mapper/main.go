@@ -13,6 +13,8 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var nonLetters = regexp.MustCompile(`[^a-z]+`)
@@ -29,8 +31,12 @@ type MapResult struct {
 }
 
 func main() {
-	r := gin.Default()
+	// Metrics wrap recovery so that requests ending in a panic are
+	// counted, as the 500s they are answered with.
+	r := gin.New()
+	r.Use(gin.Logger(), newHTTPMetrics(prometheus.DefaultRegisterer).observe, gin.Recovery())
 
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"ok": true})
 	})
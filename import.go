@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ndjsonType = "application/x-ndjson"
+
+	// maxImportLine bounds the size of a single NDJSON record.
+	maxImportLine = 1 << 20
+)
+
+// Import modes. In all-or-nothing mode nothing is created unless every
+// line is valid and new; in best-effort mode each valid line is created
+// independently.
+const (
+	importAllOrNothing = "all-or-nothing"
+	importBestEffort   = "best-effort"
+)
+
+// importResult is the outcome of one NDJSON line. Status is "created" on
+// success or the errorResponse code describing the failure.
+type importResult struct {
+	Line    int    `json:"line" xml:"line,attr"`
+	ID      string `json:"id,omitempty" xml:"id,omitempty"`
+	Status  string `json:"status" xml:"status"`
+	Message string `json:"message,omitempty" xml:"message,omitempty"`
+
+	// Details lists every failing field of a validation_error line.
+	Details validationErrors `json:"details,omitempty" xml:"details,omitempty"`
+}
+
+// importReport is the response body for an import.
+type importReport struct {
+	XMLName xml.Name       `json:"-" xml:"import"`
+	Mode    string         `json:"mode" xml:"mode,attr"`
+	Created int            `json:"created" xml:"created,attr"`
+	Failed  int            `json:"failed" xml:"failed,attr"`
+	Results []importResult `json:"results" xml:"result"`
+}
+
+// customMethods routes "/albums:verb" style requests. Gin cannot match a
+// literal colon inside a path segment, so the route captures everything
+// after "/albums" as the verb parameter and dispatches on it here.
+func customMethods(handlers map[string]gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h, ok := handlers[c.Param("verb")]
+		if !ok {
+			noRoute(c)
+			return
+		}
+		h(c)
+	}
+}
+
+// importAlbums creates albums from an NDJSON request body, one album per
+// line, and reports the outcome of every line.
+func (s *server) importAlbums(c *gin.Context) {
+	if c.ContentType() != ndjsonType {
+		render(c, http.StatusUnsupportedMediaType, errorResponse{
+			Error:   "unsupported_media_type",
+			Message: fmt.Sprintf("Content-Type must be %s", ndjsonType),
+		})
+		return
+	}
+
+	mode := c.DefaultQuery("mode", importAllOrNothing)
+	if mode != importAllOrNothing && mode != importBestEffort {
+		render(c, http.StatusBadRequest, errorResponse{
+			Error:   "invalid_mode",
+			Message: fmt.Sprintf("mode must be %s or %s", importAllOrNothing, importBestEffort),
+		})
+		return
+	}
+
+	report := importReport{Mode: mode, Results: []importResult{}}
+	var batch []album // valid albums held back in all-or-nothing mode
+	var batchAt []int // index in report.Results of each album in batch
+	seen := make(map[string]bool)
+
+	sc := bufio.NewScanner(c.Request.Body)
+	sc.Buffer(make([]byte, 0, 64*1024), maxImportLine)
+	line := 0
+	for sc.Scan() {
+		line++
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+
+		a, res := parseImportLine(line, sc.Bytes())
+		if res.Status == "" && seen[a.ID] {
+			res.Status = "duplicate_id"
+			res.Message = fmt.Sprintf("album with ID '%s' appears earlier in the import", a.ID)
+		}
+		switch {
+		case res.Status != "":
+		case mode == importBestEffort:
+			res = s.importOne(a, res)
+		default:
+			batch = append(batch, a)
+			batchAt = append(batchAt, len(report.Results))
+		}
+		if res.Status == "" || res.Status == "created" {
+			seen[a.ID] = true
+		}
+		report.Results = append(report.Results, res)
+	}
+	if err := sc.Err(); err != nil {
+		if mode == importAllOrNothing || len(report.Results) == 0 {
+			render(c, http.StatusBadRequest, errorResponse{
+				Error:   "invalid_request",
+				Message: fmt.Sprintf("failed to read request body: %v", err),
+			})
+			return
+		}
+		report.Results = append(report.Results, importResult{Line: line + 1, Status: "invalid_request", Message: err.Error()})
+	}
+
+	if mode == importAllOrNothing {
+		s.importBatch(batch, batchAt, report.Results)
+	}
+	for _, res := range report.Results {
+		if res.Status == "created" {
+			report.Created++
+		} else {
+			report.Failed++
+		}
+	}
+
+	switch {
+	case mode == importBestEffort:
+		render(c, http.StatusOK, report)
+	case report.Failed > 0:
+		render(c, http.StatusUnprocessableEntity, report)
+	default:
+		render(c, http.StatusCreated, report)
+	}
+}
+
+// parseImportLine decodes and validates one line, generating an ID for an
+// album without one. The returned result has an empty Status when the
+// album is ready to be created.
+func parseImportLine(line int, b []byte) (album, importResult) {
+	res := importResult{Line: line}
+	var a album
+	if err := json.Unmarshal(b, &a); err != nil {
+		res.Status = "invalid_json"
+		res.Message = fmt.Sprintf("failed to parse line: %v", err)
+		return a, res
+	}
+	if a.ID == "" {
+		a.ID = newAlbumID()
+	}
+	res.ID = a.ID
+	if err := validateAlbum(&a); err != nil {
+		res.Status = "validation_error"
+		res.Message = err.Error()
+		res.Details = fieldErrors(err)
+	}
+	return a, res
+}
+
+// importOne creates a single album, reporting the outcome in res.
+func (s *server) importOne(a album, res importResult) importResult {
+	if _, err := s.store.Create(a); err != nil {
+		return s.importError(a, res, err)
+	}
+	res.Status = "created"
+	return res
+}
+
+// importError records a failed create in res.
+func (s *server) importError(a album, res importResult, err error) importResult {
+	if errors.Is(err, errDuplicateID) {
+		res.Status = "duplicate_id"
+		res.Message = fmt.Sprintf("album with ID '%s' already exists", a.ID)
+	} else {
+		res.Status = "storage_error"
+		res.Message = err.Error()
+	}
+	return res
+}
+
+// importBatch creates the albums of an all-or-nothing import with a
+// single store write, recording each outcome in results at the matching
+// index of at. Nothing is created if any line already failed or the
+// store rejects one of the albums. Lines not created because of another
+// line's failure are marked "aborted".
+func (s *server) importBatch(batch []album, at []int, results []importResult) {
+	if len(batch) > 0 && len(batch) == len(results) {
+		_, err := s.store.CreateBatch(batch)
+		if err == nil {
+			for _, i := range at {
+				results[i].Status = "created"
+			}
+			return
+		}
+		var be *batchError
+		if !errors.As(err, &be) {
+			// The store failed as a whole rather than over one album.
+			for _, i := range at {
+				results[i].Status = "storage_error"
+				results[i].Message = err.Error()
+			}
+			return
+		}
+		i := at[be.index]
+		results[i] = s.importError(batch[be.index], results[i], be.err)
+	}
+	for _, i := range at {
+		if results[i].Status == "" {
+			results[i].Status = "aborted"
+			results[i].Message = "not created because another line failed"
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// jwtLeeway is how far the clocks of token issuers may drift from
+	// ours when checking exp and nbf.
+	jwtLeeway = time.Minute
+
+	// minJWTSecret is the shortest HS256 key accepted, the size of the
+	// hash as RFC 7518 requires.
+	minJWTSecret = sha256.Size
+)
+
+var (
+	errTokenMalformed = errors.New("token is malformed")
+	errTokenAlgorithm = errors.New("token is not signed with an accepted algorithm")
+	errTokenSignature = errors.New("token signature is invalid")
+	errTokenExpired   = errors.New("token has expired")
+	errTokenNotYet    = errors.New("token is not valid yet")
+	errTokenIssuer    = errors.New("token has the wrong issuer")
+	errTokenAudience  = errors.New("token is not meant for this service")
+)
+
+// jwtClaims holds the registered claims of a token that the service
+// checks. Times are NumericDates: seconds since the epoch, possibly with
+// a fraction.
+type jwtClaims struct {
+	Issuer    string     `json:"iss"`
+	Subject   string     `json:"sub"`
+	Audience  jwtStrings `json:"aud"`
+	ExpiresAt *float64   `json:"exp"`
+	NotBefore *float64   `json:"nbf"`
+
+	// Extra holds every claim, for those the service does not know of.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// jwtStrings is a claim, such as aud, that is either a single string or
+// an array of them.
+type jwtStrings []string
+
+func (a *jwtStrings) UnmarshalJSON(b []byte) error {
+	if bytes.HasPrefix(b, []byte(`"`)) {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		*a = jwtStrings{s}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+// jwtVerifier checks compact-serialized JSON Web Tokens signed with HS256
+// or RS256. A token must be signed with one of the configured keys, come
+// from issuer, name audience and carry an expiry.
+type jwtVerifier struct {
+	secret    []byte         // HS256 key; nil to reject HS256
+	publicKey *rsa.PublicKey // RS256 key; nil to reject RS256
+	issuer    string
+	audience  string
+}
+
+// newJWTVerifier returns a verifier for the HS256 secret and the RS256
+// public key in the PEM file at keyPath, either of which may be empty.
+// It returns nil if both are.
+func newJWTVerifier(secret, keyPath, issuer, audience string) (*jwtVerifier, error) {
+	if secret == "" && keyPath == "" {
+		return nil, nil
+	}
+	if issuer == "" || audience == "" {
+		return nil, errors.New("JWT authentication needs auth-jwt-issuer and auth-jwt-audience")
+	}
+	v := &jwtVerifier{issuer: issuer, audience: audience}
+	if secret != "" {
+		if len(secret) < minJWTSecret {
+			return nil, fmt.Errorf("auth-jwt-secret must be at least %d bytes", minJWTSecret)
+		}
+		v.secret = []byte(secret)
+	}
+	if keyPath != "" {
+		key, err := readRSAPublicKey(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("auth-jwt-public-key: %w", err)
+		}
+		v.publicKey = key
+	}
+	return v, nil
+}
+
+// readRSAPublicKey reads an RSA public key from a PEM file, in either
+// PKIX ("PUBLIC KEY") or PKCS #1 ("RSA PUBLIC KEY") form.
+func readRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("got a %T, want an RSA public key", key)
+		}
+		return rsaKey, nil
+	default:
+		return nil, fmt.Errorf("unexpected PEM block %q", block.Type)
+	}
+}
+
+// verify checks the signature and claims of token at time now and
+// returns its claims.
+func (v *jwtVerifier) verify(token string, now time.Time) (jwtClaims, error) {
+	var claims jwtClaims
+	header, payload, sig, ok := splitJWT(token)
+	if !ok {
+		return claims, errTokenMalformed
+	}
+
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTPart(header, &h); err != nil {
+		return claims, errTokenMalformed
+	}
+	// The key is chosen by the algorithm, so that a token cannot have its
+	// RS256 public key checked as an HS256 secret.
+	signed := token[:len(header)+1+len(payload)]
+	switch {
+	case h.Alg == "HS256" && v.secret != nil:
+		mac := hmac.New(sha256.New, v.secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return claims, errTokenSignature
+		}
+	case h.Alg == "RS256" && v.publicKey != nil:
+		digest := sha256.Sum256([]byte(signed))
+		if rsa.VerifyPKCS1v15(v.publicKey, crypto.SHA256, digest[:], sig) != nil {
+			return claims, errTokenSignature
+		}
+	default:
+		return claims, errTokenAlgorithm
+	}
+
+	if err := decodeJWTPart(payload, &claims); err != nil {
+		return claims, errTokenMalformed
+	}
+	if err := decodeJWTPart(payload, &claims.Extra); err != nil {
+		return claims, errTokenMalformed
+	}
+	if claims.ExpiresAt == nil {
+		return claims, fmt.Errorf("%w: exp claim is missing", errTokenMalformed)
+	}
+	if now.Add(-jwtLeeway).After(numericDate(*claims.ExpiresAt)) {
+		return claims, errTokenExpired
+	}
+	if claims.NotBefore != nil && now.Add(jwtLeeway).Before(numericDate(*claims.NotBefore)) {
+		return claims, errTokenNotYet
+	}
+	if claims.Issuer != v.issuer {
+		return claims, errTokenIssuer
+	}
+	for _, aud := range claims.Audience {
+		if aud == v.audience {
+			return claims, nil
+		}
+	}
+	return claims, errTokenAudience
+}
+
+// splitJWT splits a compact JWS into its base64url-encoded header and
+// payload, and its decoded signature.
+func splitJWT(token string) (header, payload string, sig []byte, ok bool) {
+	header, rest, ok1 := strings.Cut(token, ".")
+	payload, encSig, ok2 := strings.Cut(rest, ".")
+	if !ok1 || !ok2 || strings.Contains(encSig, ".") {
+		return "", "", nil, false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encSig)
+	if err != nil {
+		return "", "", nil, false
+	}
+	return header, payload, sig, true
+}
+
+// decodeJWTPart decodes a base64url-encoded JSON object into v.
+func decodeJWTPart(part string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func numericDate(secs float64) time.Time {
+	return time.UnixMicro(int64(secs * 1e6))
+}
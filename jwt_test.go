@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testJWTSecret = "0123456789abcdef0123456789abcdef"
+
+var testNow = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// signJWT returns a token with claims signed by key as alg: a []byte
+// secret for HS256, an *rsa.PrivateKey for RS256, or nil for none.
+func signJWT(t *testing.T, alg string, key any, claims map[string]any) string {
+	t.Helper()
+	enc := func(v any) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := enc(map[string]string{"alg": alg, "typ": "JWT"}) + "." + enc(claims)
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// testClaims returns claims that verifiers for https://issuer.example and
+// the albums audience accept at testNow, changed by the pairs in kv; a
+// nil value removes the claim.
+func testClaims(kv ...any) map[string]any {
+	claims := map[string]any{
+		"iss": "https://issuer.example",
+		"sub": "alice",
+		"aud": "albums",
+		"exp": testNow.Add(time.Hour).Unix(),
+	}
+	for i := 0; i < len(kv); i += 2 {
+		if kv[i+1] == nil {
+			delete(claims, kv[i].(string))
+		} else {
+			claims[kv[i].(string)] = kv[i+1]
+		}
+	}
+	return claims
+}
+
+// newTestRSAKey generates an RSA key and writes its public half to a PEM
+// file, returning the key, the file's path and its contents.
+func newTestRSAKey(t *testing.T) (*rsa.PrivateKey, string, []byte) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pemBytes, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return key, path, pemBytes
+}
+
+func TestJWTVerify(t *testing.T) {
+	key, keyPath, pemBytes := newTestRSAKey(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	both, err := newJWTVerifier(testJWTSecret, keyPath, "https://issuer.example", "albums")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaOnly, err := newJWTVerifier("", keyPath, "https://issuer.example", "albums")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hmacOnly, err := newJWTVerifier(testJWTSecret, "", "https://issuer.example", "albums")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte(testJWTSecret)
+	leeway := jwtLeeway + time.Second
+
+	tests := []struct {
+		name    string
+		v       *jwtVerifier
+		token   string
+		wantErr error
+	}{
+		{"HS256", both, signJWT(t, "HS256", secret, testClaims()), nil},
+		{"RS256", both, signJWT(t, "RS256", key, testClaims()), nil},
+		{"audience list", both, signJWT(t, "HS256", secret, testClaims("aud", []string{"other", "albums"})), nil},
+		{"fractional dates", both, signJWT(t, "HS256", secret, testClaims("exp", float64(testNow.Unix())+0.5)), nil},
+
+		// Algorithm confusion
+		{"alg none", both, signJWT(t, "none", nil, testClaims()), errTokenAlgorithm},
+		{"alg none with signature", both, signJWT(t, "none", secret, testClaims()), errTokenAlgorithm},
+		{"HS256 keyed with the public key", rsaOnly, signJWT(t, "HS256", pemBytes, testClaims()), errTokenAlgorithm},
+		{"HS256 keyed with the public key, both keys", both, signJWT(t, "HS256", pemBytes, testClaims()), errTokenSignature},
+		{"RS256 without a public key", hmacOnly, signJWT(t, "RS256", key, testClaims()), errTokenAlgorithm},
+		{"unknown alg", both, signJWT(t, "ES256", nil, testClaims()), errTokenAlgorithm},
+
+		// Signatures
+		{"wrong secret", both, signJWT(t, "HS256", []byte(strings.Repeat("x", minJWTSecret)), testClaims()), errTokenSignature},
+		{"wrong RSA key", both, signJWT(t, "RS256", otherKey, testClaims()), errTokenSignature},
+		{"tampered payload", both, tamper(signJWT(t, "HS256", secret, testClaims()), testClaims("sub", "mallory")), errTokenSignature},
+		{"empty signature", both, stripSignature(signJWT(t, "HS256", secret, testClaims())), errTokenSignature},
+
+		// Times
+		{"expired", both, signJWT(t, "HS256", secret, testClaims("exp", testNow.Add(-leeway).Unix())), errTokenExpired},
+		{"expired within leeway", both, signJWT(t, "HS256", secret, testClaims("exp", testNow.Add(-jwtLeeway/2).Unix())), nil},
+		{"no expiry", both, signJWT(t, "HS256", secret, testClaims("exp", nil)), errTokenMalformed},
+		{"not yet valid", both, signJWT(t, "HS256", secret, testClaims("nbf", testNow.Add(leeway).Unix())), errTokenNotYet},
+		{"not yet valid within leeway", both, signJWT(t, "HS256", secret, testClaims("nbf", testNow.Add(jwtLeeway/2).Unix())), nil},
+
+		// Issuer and audience
+		{"wrong issuer", both, signJWT(t, "HS256", secret, testClaims("iss", "https://evil.example")), errTokenIssuer},
+		{"no issuer", both, signJWT(t, "HS256", secret, testClaims("iss", nil)), errTokenIssuer},
+		{"wrong audience", both, signJWT(t, "HS256", secret, testClaims("aud", "photos")), errTokenAudience},
+		{"wrong audience list", both, signJWT(t, "HS256", secret, testClaims("aud", []string{"photos"})), errTokenAudience},
+		{"no audience", both, signJWT(t, "HS256", secret, testClaims("aud", nil)), errTokenAudience},
+
+		// Malformed tokens
+		{"two parts", both, "a.b", errTokenMalformed},
+		{"four parts", both, signJWT(t, "HS256", secret, testClaims()) + ".x", errTokenMalformed},
+		{"bad base64", both, "!!.!!.!!", errTokenMalformed},
+		{"header not JSON", both, base64.RawURLEncoding.EncodeToString([]byte("HS256")) + ".e30.", errTokenMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := tt.v.verify(tt.token, testNow)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && claims.Subject != "alice" {
+				t.Errorf("got subject %q, want alice", claims.Subject)
+			}
+		})
+	}
+}
+
+// tamper replaces the payload of token with claims, keeping its header
+// and signature.
+func tamper(token string, claims map[string]any) string {
+	parts := strings.Split(token, ".")
+	b, _ := json.Marshal(claims)
+	parts[1] = base64.RawURLEncoding.EncodeToString(b)
+	return strings.Join(parts, ".")
+}
+
+// stripSignature removes the signature of token, keeping its final dot.
+func stripSignature(token string) string {
+	return token[:strings.LastIndex(token, ".")+1]
+}
+
+func TestNewJWTVerifier(t *testing.T) {
+	_, keyPath, _ := newTestRSAKey(t)
+	tests := []struct {
+		name                         string
+		secret, keyPath, issuer, aud string
+		wantErr                      bool
+	}{
+		{"disabled", "", "", "", "", false},
+		{"secret", testJWTSecret, "", "iss", "aud", false},
+		{"public key", "", keyPath, "iss", "aud", false},
+		{"short secret", testJWTSecret[:minJWTSecret-1], "", "iss", "aud", true},
+		{"no issuer", testJWTSecret, "", "", "aud", true},
+		{"no audience", testJWTSecret, "", "iss", "", true},
+		{"missing key file", "", filepath.Join(t.TempDir(), "none.pem"), "iss", "aud", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newJWTVerifier(tt.secret, tt.keyPath, tt.issuer, tt.aud)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
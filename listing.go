@@ -0,0 +1,294 @@
+package main
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// albumPage is the response envelope for album listings.
+type albumPage struct {
+	XMLName    xml.Name `json:"-" xml:"albums"`
+	Albums     []album  `json:"albums" xml:"album"`
+	NextCursor string   `json:"next_cursor,omitempty" xml:"next_cursor,omitempty"`
+}
+
+// sortKey is one field of a sort=price,-title style ordering.
+type sortKey struct {
+	field string
+	desc  bool
+}
+
+// albumSortFields compares two albums by each sortable album field,
+// keyed by its JSON name. Amounts in different currencies do not compare,
+// so price orders by currency first and then by amount within each.
+var albumSortFields = map[string]func(a, b album) int{
+	"id":     func(a, b album) int { return strings.Compare(a.ID, b.ID) },
+	"title":  func(a, b album) int { return strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title)) },
+	"artist": func(a, b album) int { return strings.Compare(strings.ToLower(a.Artist), strings.ToLower(b.Artist)) },
+	"price": func(a, b album) int {
+		if c := strings.Compare(a.Currency, b.Currency); c != 0 {
+			return c
+		}
+		return a.Price.cmp(b.Price)
+	},
+	"currency": func(a, b album) int { return strings.Compare(a.Currency, b.Currency) },
+}
+
+// albumQuery holds the filters and ordering for an album listing.
+type albumQuery struct {
+	artist   string
+	title    string
+	currency string
+	minPrice *amount
+	maxPrice *amount
+	sort     []sortKey
+	sortSpec string // the sort parameter as given, bound into cursors
+}
+
+// match reports whether a passes every filter in q.
+func (q albumQuery) match(a album) bool {
+	if q.artist != "" && !strings.EqualFold(a.Artist, q.artist) {
+		return false
+	}
+	if q.title != "" && !strings.Contains(strings.ToLower(a.Title), strings.ToLower(q.title)) {
+		return false
+	}
+	if q.currency != "" && a.Currency != q.currency {
+		return false
+	}
+	if q.minPrice != nil && a.Price.cmp(*q.minPrice) < 0 {
+		return false
+	}
+	if q.maxPrice != nil && a.Price.cmp(*q.maxPrice) > 0 {
+		return false
+	}
+	return true
+}
+
+// selectsAll reports whether q neither filters nor sorts, so that its
+// pages follow the store's own order.
+func (q albumQuery) selectsAll() bool {
+	return q.artist == "" && q.title == "" && q.currency == "" &&
+		q.minPrice == nil && q.maxPrice == nil && len(q.sort) == 0
+}
+
+// compare orders albums by q's sort keys, falling back to creation order
+// so that every album has a unique position.
+func (q albumQuery) compare(a, b album) int {
+	for _, k := range q.sort {
+		c := albumSortFields[k.field](a, b)
+		if k.desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(a.Seq, b.Seq)
+}
+
+// pageCursor identifies the last album on a page by its position in the
+// requested ordering. Clients receive it as an opaque string and pass it
+// back unchanged to fetch the next page.
+type pageCursor struct {
+	Sort     string `json:"o,omitempty"`
+	Seq      uint64 `json:"s"`
+	ID       string `json:"i,omitempty"`
+	Title    string `json:"t,omitempty"`
+	Artist   string `json:"a,omitempty"`
+	Price    amount `json:"p,omitzero"`
+	Currency string `json:"c,omitempty"`
+}
+
+// cursorAfter returns the cursor positioned at a under q's ordering.
+func cursorAfter(a album, q albumQuery) pageCursor {
+	pc := pageCursor{Sort: q.sortSpec, Seq: a.Seq}
+	for _, k := range q.sort {
+		switch k.field {
+		case "id":
+			pc.ID = a.ID
+		case "title":
+			pc.Title = a.Title
+		case "artist":
+			pc.Artist = a.Artist
+		case "price":
+			pc.Price, pc.Currency = a.Price, a.Currency
+		case "currency":
+			pc.Currency = a.Currency
+		}
+	}
+	return pc
+}
+
+// album returns the sort key values held by the cursor as an album.
+func (pc pageCursor) album() album {
+	return album{ID: pc.ID, Title: pc.Title, Artist: pc.Artist, Price: pc.Price, Currency: pc.Currency, Seq: pc.Seq}
+}
+
+func (pc pageCursor) encode() string {
+	b, _ := json.Marshal(pc)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (pageCursor, error) {
+	var pc pageCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return pc, err
+	}
+	err = json.Unmarshal(b, &pc)
+	return pc, err
+}
+
+// parseAlbumQuery reads the filter and sort query parameters. On failure
+// it writes a 400 response and returns false.
+func parseAlbumQuery(c *gin.Context) (albumQuery, bool) {
+	q := albumQuery{
+		artist:   strings.TrimSpace(c.Query("artist")),
+		title:    strings.TrimSpace(c.Query("title")),
+		currency: strings.ToUpper(strings.TrimSpace(c.Query("currency"))),
+	}
+
+	for _, p := range []struct {
+		name string
+		dst  **amount
+	}{{"min_price", &q.minPrice}, {"max_price", &q.maxPrice}} {
+		v := c.Query(p.name)
+		if v == "" {
+			continue
+		}
+		f, err := parseAmount(v)
+		if err != nil || f.sign() < 0 {
+			render(c, http.StatusBadRequest, errorResponse{
+				Error:   "invalid_price_range",
+				Message: fmt.Sprintf("%s must be a non-negative number", p.name),
+			})
+			return q, false
+		}
+		*p.dst = &f
+	}
+	// A price range is only meaningful in one currency.
+	if (q.minPrice != nil || q.maxPrice != nil) && q.currency == "" {
+		render(c, http.StatusBadRequest, errorResponse{
+			Error:   "currency_required",
+			Message: "min_price and max_price need a currency filter to compare against",
+		})
+		return q, false
+	}
+	if q.minPrice != nil && q.maxPrice != nil && q.minPrice.cmp(*q.maxPrice) > 0 {
+		render(c, http.StatusBadRequest, errorResponse{
+			Error:   "invalid_price_range",
+			Message: "min_price cannot be greater than max_price",
+		})
+		return q, false
+	}
+
+	q.sortSpec = c.Query("sort")
+	if q.sortSpec == "" {
+		return q, true
+	}
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(q.sortSpec, ",") {
+		k := sortKey{field: strings.TrimSpace(part)}
+		if rest, ok := strings.CutPrefix(k.field, "-"); ok {
+			k.field, k.desc = rest, true
+		}
+		if _, ok := albumSortFields[k.field]; !ok || seen[k.field] {
+			render(c, http.StatusBadRequest, errorResponse{
+				Error:   "invalid_sort",
+				Message: fmt.Sprintf("cannot sort by %q; sort is a comma-separated list of id, title, artist, price or currency, each optionally prefixed with -", part),
+			})
+			return q, false
+		}
+		seen[k.field] = true
+		q.sort = append(q.sort, k)
+	}
+	return q, true
+}
+
+// parseLimit reads the limit query parameter. On failure it writes a 400
+// response and returns false.
+func parseLimit(c *gin.Context) (int, bool) {
+	v := c.Query("limit")
+	if v == "" {
+		return defaultPageLimit, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > maxPageLimit {
+		render(c, http.StatusBadRequest, errorResponse{
+			Error:   "invalid_limit",
+			Message: fmt.Sprintf("limit must be an integer between 1 and %d", maxPageLimit),
+		})
+		return 0, false
+	}
+	return n, true
+}
+
+// parsePageParams reads the limit and cursor query parameters, checking
+// that the cursor was issued for q's ordering. On failure it writes a 400
+// response and returns false.
+func parsePageParams(c *gin.Context, q albumQuery) (limit int, after *pageCursor, ok bool) {
+	if limit, ok = parseLimit(c); !ok {
+		return 0, nil, false
+	}
+
+	if v := c.Query("cursor"); v != "" {
+		pc, err := decodeCursor(v)
+		if err != nil || pc.Sort != q.sortSpec {
+			render(c, http.StatusBadRequest, errorResponse{
+				Error:   "invalid_cursor",
+				Message: "cursor is malformed or was issued for a different sort; pass back the next_cursor from a previous page",
+			})
+			return 0, nil, false
+		}
+		after = &pc
+	}
+	return limit, after, true
+}
+
+// listAlbums filters and orders albums by q and returns up to limit of
+// them following the cursor. Positions are keyed on the sort values plus
+// creation order, so albums created between page fetches never shift
+// earlier pages.
+func listAlbums(albums []album, q albumQuery, after *pageCursor, limit int) albumPage {
+	matched := slices.DeleteFunc(albums, func(a album) bool { return !q.match(a) })
+	if len(q.sort) > 0 {
+		slices.SortFunc(matched, q.compare)
+	}
+
+	start := 0
+	if after != nil {
+		last := after.album()
+		start = sort.Search(len(matched), func(i int) bool {
+			return q.compare(matched[i], last) > 0
+		})
+	}
+	return firstPage(matched[start:], q, limit)
+}
+
+// firstPage returns up to limit of albums, which are in q's order, with
+// a cursor to the next page if any are left over.
+func firstPage(albums []album, q albumQuery, limit int) albumPage {
+	if len(albums) <= limit {
+		return albumPage{Albums: albums}
+	}
+	return albumPage{
+		Albums:     albums[:limit],
+		NextCursor: cursorAfter(albums[limit-1], q).encode(),
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestGetAlbumsPaging(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		// inserted after the first page is read
+		inserts []string
+		want    string
+	}{
+		{
+			name:    "creation order",
+			query:   "limit=2",
+			inserts: []string{`{"id":"4","title":"A Love Supreme","artist":"John Coltrane","price":"24.99"}`},
+			want:    "1,2,3,4",
+		},
+		{
+			name:  "sorted",
+			query: "sort=title&limit=2",
+			inserts: []string{
+				`{"id":"4","title":"A Love Supreme","artist":"John Coltrane","price":"24.99"}`, // before the cursor
+				`{"id":"5","title":"Kind of Blue","artist":"Miles Davis","price":"29.99"}`,
+			},
+			want: "1,2,5,3",
+		},
+		{
+			name:  "sorted descending",
+			query: "sort=-price&limit=2",
+			inserts: []string{
+				`{"id":"4","title":"A Love Supreme","artist":"John Coltrane","price":"99.99"}`, // before the cursor
+				`{"id":"5","title":"Kind of Blue","artist":"Miles Davis","price":"19.99"}`,
+			},
+			want: "1,3,5,2",
+		},
+		{
+			name:  "filtered",
+			query: "currency=USD&limit=2",
+			inserts: []string{
+				`{"id":"4","title":"A Love Supreme","artist":"John Coltrane","price":"24.99","currency":"EUR"}`,
+				`{"id":"5","title":"Kind of Blue","artist":"Miles Davis","price":"29.99","currency":"USD"}`,
+			},
+			want: "1,2,3,5",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestApp(t, testConfig(), nil).router
+
+			var ids []string
+			cursor := ""
+			for page := 0; ; page++ {
+				target := "/albums?" + tt.query
+				if cursor != "" {
+					target += "&cursor=" + url.QueryEscape(cursor)
+				}
+				w := send(h, http.MethodGet, target, "")
+				wantStatus(t, w, http.StatusOK)
+				p := decode[albumPage](t, w)
+				for _, a := range p.Albums {
+					ids = append(ids, a.ID)
+				}
+				if page == 0 {
+					for _, body := range tt.inserts {
+						wantStatus(t, send(h, http.MethodPost, "/albums", body), http.StatusCreated)
+					}
+				}
+				if p.NextCursor == "" {
+					break
+				}
+				cursor = p.NextCursor
+			}
+			if got := strings.Join(ids, ","); got != tt.want {
+				t.Errorf("paged through %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetAlbumsCursorForOtherSort(t *testing.T) {
+	h := newTestApp(t, testConfig(), nil).router
+	p := decode[albumPage](t, send(h, http.MethodGet, "/albums?sort=title&limit=1", ""))
+	if p.NextCursor == "" {
+		t.Fatal("no next_cursor")
+	}
+	w := send(h, http.MethodGet, "/albums?limit=1&cursor="+url.QueryEscape(p.NextCursor), "")
+	wantError(t, w, http.StatusBadRequest, "invalid_cursor")
+}
+
+func TestGetAlbumsPriceNeedsCurrency(t *testing.T) {
+	h := newTestApp(t, testConfig(), nil).router
+
+	for _, query := range []string{"min_price=20", "max_price=40", "min_price=20&max_price=40"} {
+		wantError(t, send(h, http.MethodGet, "/albums?"+query, ""), http.StatusBadRequest, "currency_required")
+	}
+
+	w := send(h, http.MethodGet, "/albums?currency=usd&min_price=20&max_price=40", "")
+	wantStatus(t, w, http.StatusOK)
+	if p := decode[albumPage](t, w); len(p.Albums) != 1 || p.Albums[0].ID != "3" {
+		t.Errorf("got %+v, want album 3", p.Albums)
+	}
+}
+
+func TestGetAlbumsSortByPrice(t *testing.T) {
+	h := newTestApp(t, testConfig(), nil).router
+	for _, body := range []string{
+		`{"id":"4","title":"A Love Supreme","artist":"John Coltrane","price":"24.99","currency":"EUR"}`,
+		`{"id":"5","title":"Kind of Blue","artist":"Miles Davis","price":"99.99","currency":"EUR"}`,
+		`{"id":"6","title":"Mingus Ah Um","artist":"Charles Mingus","price":"1999","currency":"JPY"}`,
+	} {
+		wantStatus(t, send(h, http.MethodPost, "/albums", body), http.StatusCreated)
+	}
+
+	tests := []struct {
+		sort string
+		want string
+	}{
+		// Grouped by currency, cheapest first within each.
+		{"price", "4,5,6,2,3,1"},
+		{"-price", "1,3,2,6,5,4"},
+	}
+	for _, tt := range tests {
+		var ids []string
+		cursor := ""
+		for {
+			target := "/albums?limit=4&sort=" + tt.sort
+			if cursor != "" {
+				target += "&cursor=" + url.QueryEscape(cursor)
+			}
+			p := decode[albumPage](t, send(h, http.MethodGet, target, ""))
+			for _, a := range p.Albums {
+				ids = append(ids, a.ID)
+			}
+			if cursor = p.NextCursor; cursor == "" {
+				break
+			}
+		}
+		if got := strings.Join(ids, ","); got != tt.want {
+			t.Errorf("sort=%s gave %s, want %s", tt.sort, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	mrand "math/rand/v2"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// requestIDHeader carries the ID that ties a request's log entries
+	// and error response together.
+	requestIDHeader = "X-Request-ID"
+
+	// requestIDKey is the gin context key holding the request ID.
+	requestIDKey = "request_id"
+
+	// maxRequestID is the longest request ID taken from a client.
+	maxRequestID = 128
+)
+
+var requestIDs ulidGenerator
+
+// newLogger returns a logger writing JSON lines to stderr at level and
+// above: debug, info, warn or error.
+func newLogger(level string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", level)
+	}
+	return slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: l})), nil
+}
+
+// accessLog logs every request the router serves, except that only a
+// sample of the successful reads is kept, as those dominate the traffic.
+type accessLog struct {
+	log    *slog.Logger
+	sample float64 // fraction of successful reads logged
+}
+
+// handle assigns the request its ID, taken from the X-Request-ID header
+// when the client or a forwarding instance sent a usable one, and logs
+// the request once it is served.
+func (l *accessLog) handle(c *gin.Context) {
+	start := time.Now()
+	id := c.GetHeader(requestIDHeader)
+	if !validRequestID(id) {
+		id = requestIDs.next(start)
+		// Set it on the request too, so that it is forwarded to the leader.
+		c.Request.Header.Set(requestIDHeader, id)
+	}
+	c.Set(requestIDKey, id)
+	c.Header(requestIDHeader, id)
+
+	c.Next()
+
+	status := c.Writer.Status()
+	level := slog.LevelInfo
+	switch {
+	case status >= 500:
+		level = slog.LevelError
+	case status >= 400:
+		level = slog.LevelWarn
+	}
+	sampled := level == slog.LevelInfo && isReadMethod(c.Request.Method) && l.sample < 1
+	if sampled && mrand.Float64() >= l.sample {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("request_id", id),
+		slog.String("method", c.Request.Method),
+		slog.String("route", c.FullPath()),
+		slog.String("path", c.Request.URL.Path),
+		slog.Int("status", status),
+		slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+		slog.Int("bytes", max(c.Writer.Size(), 0)),
+		slog.String("client_ip", c.ClientIP()),
+	}
+	if v, ok := c.Get(principalKey); ok {
+		attrs = append(attrs, slog.String("principal", v.(principal).Name))
+	}
+	if sampled {
+		attrs = append(attrs, slog.Float64("sample_rate", l.sample))
+	}
+	l.log.LogAttrs(c.Request.Context(), level, "request", attrs...)
+}
+
+// validRequestID reports whether id is short and made of characters that
+// are safe to log and echo back.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestID {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		case r == '-' || r == '_' || r == '.' || r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// recoverPanic answers a request whose handler panicked with 500, logging
+// the panic. A handler panicking with http.ErrAbortHandler has already
+// logged why; the panic is passed on for net/http to cut the response off.
+func recoverPanic(c *gin.Context, err any) {
+	if err == http.ErrAbortHandler {
+		panic(err)
+	}
+	slog.Error("handler panicked", "request_id", c.GetString(requestIDKey), "panic", fmt.Sprint(err), "stack", string(debug.Stack()))
+	render(c, http.StatusInternalServerError, errorResponse{
+		Error:   "internal_error",
+		Message: "the server failed to handle the request",
+	})
+	c.Abort()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	h := newTestApp(t, testConfig(), nil).router
+
+	tests := []struct {
+		name   string
+		sent   string
+		accept string
+		keep   bool // whether the sent ID is used
+	}{
+		{"generated", "", "", false},
+		{"from the client", "req-42:a.b_c", "", true},
+		{"in XML", "req-43", "application/xml", true},
+		{"unsafe", "req 44\n", "", false},
+		{"too long", strings.Repeat("x", maxRequestID+1), "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := []string{"Accept", tt.accept}
+			if tt.sent != "" {
+				header = append(header, requestIDHeader, tt.sent)
+			}
+			w := send(h, http.MethodGet, "/albums/nope", "", header...)
+			wantStatus(t, w, http.StatusNotFound)
+
+			id := w.Header().Get(requestIDHeader)
+			switch {
+			case tt.keep && id != tt.sent:
+				t.Errorf("got %s %q, want %q", requestIDHeader, id, tt.sent)
+			case !tt.keep && (id == "" || id == tt.sent):
+				t.Errorf("got %s %q, want a generated one", requestIDHeader, id)
+			}
+			if !strings.Contains(w.Body.String(), id) {
+				t.Errorf("error body lacks the request ID %s: %s", id, w.Body)
+			}
+		})
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := testConfig()
+	cfg.AccessLogSample = 0
+	cfg.AuthAPIKeys = "ci:editor:" + hashKey("editor-key")
+	h := newLoggingTestApp(t, cfg, nil, &buf).router
+
+	// Successful reads are sampled away entirely; everything else is
+	// logged.
+	wantStatus(t, send(h, http.MethodGet, "/albums/1", ""), http.StatusOK)
+	wantStatus(t, send(h, http.MethodGet, "/albums/nope", "", requestIDHeader, "req-1"), http.StatusNotFound)
+	wantStatus(t, send(h, http.MethodPost, "/albums", `{"id":"7","title":"T","artist":"A","price":1}`, "X-API-Key", "editor-key", requestIDHeader, "req-2"), http.StatusCreated)
+
+	var entries []map[string]any
+	for line := range strings.Lines(buf.String()) {
+		var e map[string]any
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+		if e["msg"] == "request" {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d access log entries, want 2: %s", len(entries), buf.String())
+	}
+	want := []map[string]any{
+		{"level": "WARN", "request_id": "req-1", "method": "GET", "route": "/albums/:id", "path": "/albums/nope", "status": 404.0},
+		{"level": "INFO", "request_id": "req-2", "method": "POST", "route": "/albums", "status": 201.0, "principal": "ci"},
+	}
+	for i, e := range entries {
+		for k, v := range want[i] {
+			if e[k] != v {
+				t.Errorf("entry %d: got %s %v, want %v", i, k, e[k], v)
+			}
+		}
+		for _, k := range []string{"latency_ms", "bytes", "client_ip"} {
+			if _, ok := e[k]; !ok {
+				t.Errorf("entry %d lacks %s", i, k)
+			}
+		}
+	}
+}
@@ -1,136 +1,635 @@
 package main
 
 import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // album represents a music record album with its details.
 type album struct {
-	ID 		string `json:"id"`
-	Title 	string `json:"title"`
-	Artist 	string `json:"artist"`
-	Price 	float64 `json:"price"`
+	ID     string `json:"id" xml:"id"`
+	Title  string `json:"title" xml:"title"`
+	Artist string `json:"artist" xml:"artist"`
+	Price  amount `json:"price" xml:"price"`
+
+	// Currency is the ISO 4217 code Price is in.
+	Currency string `json:"currency" xml:"currency"`
+
+	// Version is assigned by the store and incremented on every write.
+	// Together with Seq it makes up the album's ETag.
+	Version int64 `json:"version" xml:"version"`
+
+	// DeletedAt is set while the album is in the trash.
+	DeletedAt *time.Time `json:"deleted_at,omitempty" xml:"deleted_at,omitempty"`
+
+	// Seq is assigned by the store on creation and orders album listings.
+	Seq uint64 `json:"-" xml:"-"`
 }
 
 // errorResponse represents an error response structure.
 type errorResponse struct {
-	Error   string `json:"error"`
-	Message string `json:"message,omitempty"`
+	XMLName xml.Name `json:"-" xml:"error"`
+	Error   string   `json:"error" xml:"code"`
+	Message string   `json:"message,omitempty" xml:"message,omitempty"`
+
+	// Details lists every failing field of a validation_error.
+	Details validationErrors `json:"details,omitempty" xml:"details,omitempty"`
+
+	// RequestID is the request's X-Request-ID, set by render.
+	RequestID string `json:"request_id,omitempty" xml:"request_id,omitempty"`
 }
 
-// validateAlbum validates the album data and returns an error if invalid.
-func validateAlbum(a *album) error {
-	if strings.TrimSpace(a.ID) == "" {
-		return fmt.Errorf("album ID is required and cannot be empty")
-	}
-	if strings.TrimSpace(a.Title) == "" {
-		return fmt.Errorf("album title is required and cannot be empty")
-	}
-	if strings.TrimSpace(a.Artist) == "" {
-		return fmt.Errorf("album artist is required and cannot be empty")
-	}
-	if a.Price < 0 {
-		return fmt.Errorf("album price cannot be negative")
+// server holds the dependencies shared by the album handlers.
+type server struct {
+	store AlbumStore
+	index *searchIndex
+}
+
+// respondStorageError reports an unexpected AlbumStore failure.
+func respondStorageError(c *gin.Context, err error) {
+	render(c, http.StatusInternalServerError, errorResponse{
+		Error:   "storage_error",
+		Message: err.Error(),
+	})
+}
+
+// respondNotFound reports that no album has the given ID.
+func respondNotFound(c *gin.Context, id string) {
+	render(c, http.StatusNotFound, errorResponse{
+		Error:   "not_found",
+		Message: fmt.Sprintf("album with ID '%s' not found", id),
+	})
+}
+
+// getAlbums returns a page of albums in the negotiated format, filtered
+// and sorted by the query parameters and following the cursor parameter
+// when one is given.
+func (s *server) getAlbums(c *gin.Context) {
+	q, ok := parseAlbumQuery(c)
+	if !ok {
+		return
 	}
-	if a.Price == 0 {
-		return fmt.Errorf("album price must be greater than zero")
+	limit, after, ok := parsePageParams(c, q)
+	if !ok {
+		return
 	}
-	return nil
-}
 
-// albumExists checks if an album with the given ID already exists.
-func albumExists(id string) bool {
-	for _, a := range albums {
-		if a.ID == id {
-			return true
+	// Pages in the store's own order are read from it directly; only
+	// filtering or sorting needs every album.
+	if q.selectsAll() {
+		var seq uint64
+		if after != nil {
+			seq = after.Seq
+		}
+		albums, err := s.store.ListAfter(seq, limit+1)
+		if err != nil {
+			respondStorageError(c, err)
+			return
 		}
+		render(c, http.StatusOK, firstPage(albums, q, limit))
+		return
 	}
-	return false
-}
 
-// getAlbums returns the list of albums as JSON.
-func getAlbums(c *gin.Context) {
-	c.IndentedJSON(http.StatusOK, albums)
+	albums, err := s.store.List()
+	if err != nil {
+		respondStorageError(c, err)
+		return
+	}
+	render(c, http.StatusOK, listAlbums(albums, q, after, limit))
 }
 
 // getAlbumByID locates the album whose ID value matches the id
 // parameter sent by the client, then returns that album as a response.
-func getAlbumByID(c *gin.Context) {
+func (s *server) getAlbumByID(c *gin.Context) {
 	id := c.Param("id")
 
 	// Validate that ID is not empty
 	if strings.TrimSpace(id) == "" {
-		c.IndentedJSON(http.StatusBadRequest, errorResponse{
+		render(c, http.StatusBadRequest, errorResponse{
 			Error:   "invalid_request",
 			Message: "album ID cannot be empty",
 		})
 		return
 	}
 
-	for _, a := range albums {
-		if a.ID == id {
-			c.IndentedJSON(http.StatusOK, a)
-			return
-		}
+	a, err := s.store.Get(id)
+	if errors.Is(err, errAlbumNotFound) {
+		respondNotFound(c, id)
+		return
 	}
-	
-	c.IndentedJSON(http.StatusNotFound, errorResponse{
-		Error:   "not_found",
-		Message: fmt.Sprintf("album with ID '%s' not found", id),
-	})
+	if err != nil {
+		respondStorageError(c, err)
+		return
+	}
+	if notModified(c, a) {
+		return
+	}
+	setETag(c, a)
+	render(c, http.StatusOK, a)
 }
 
-// postAlbums adds an album from JSON received in the request body.
-func postAlbums(c *gin.Context) {
+// postAlbums adds an album from the request body, generating its ID if
+// the body does not include one.
+func (s *server) postAlbums(c *gin.Context) {
 	var newAlbum album
 
-	// Bind JSON and handle binding errors
-	if err := c.BindJSON(&newAlbum); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, errorResponse{
-			Error:   "invalid_json",
-			Message: fmt.Sprintf("failed to parse request body: %v", err),
-		})
+	// Bind the body in whichever format it was sent
+	if !bindAlbum(c, &newAlbum) {
 		return
 	}
 
+	// Generate an ID if the client did not choose one
+	if newAlbum.ID == "" {
+		newAlbum.ID = newAlbumID()
+	}
+
 	// Validate album data
 	if err := validateAlbum(&newAlbum); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, errorResponse{
-			Error:   "validation_error",
-			Message: err.Error(),
-		})
+		respondValidationError(c, err)
 		return
 	}
 
-	// Check for duplicate ID
-	if albumExists(newAlbum.ID) {
-		c.IndentedJSON(http.StatusConflict, errorResponse{
+	// Add the new album, rejecting duplicate IDs
+	created, err := s.store.Create(newAlbum)
+	if errors.Is(err, errDuplicateID) {
+		render(c, http.StatusConflict, errorResponse{
 			Error:   "duplicate_id",
 			Message: fmt.Sprintf("album with ID '%s' already exists", newAlbum.ID),
 		})
 		return
 	}
+	if err != nil {
+		respondStorageError(c, err)
+		return
+	}
+	setETag(c, created)
+	c.Header("Location", "/albums/"+created.ID)
+	render(c, http.StatusCreated, created)
+}
+
+// putAlbum replaces the album identified by the id parameter with the
+// album in the request body, provided the If-Match header matches its
+// current revision.
+func (s *server) putAlbum(c *gin.Context) {
+	id := c.Param("id")
+
+	revs, ok := parseIfMatch(c)
+	if !ok {
+		return
+	}
+
+	var a album
+	if !bindAlbum(c, &a) {
+		return
+	}
+
+	// The body may omit the ID, but must not name a different album
+	if a.ID == "" {
+		a.ID = id
+	}
+	if a.ID != id {
+		render(c, http.StatusBadRequest, errorResponse{
+			Error:   "id_mismatch",
+			Message: fmt.Sprintf("body ID '%s' does not match path ID '%s'", a.ID, id),
+		})
+		return
+	}
+
+	if err := validateAlbum(&a); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	var updated album
+	err := withIfMatch(revs, func(ifRev revision) (err error) {
+		updated, err = s.store.Update(a, ifRev)
+		return err
+	})
+	switch {
+	case errors.Is(err, errAlbumNotFound):
+		respondNotFound(c, id)
+		return
+	case errors.Is(err, errVersionMismatch):
+		respondPreconditionFailed(c, id)
+		return
+	case err != nil:
+		respondStorageError(c, err)
+		return
+	}
+	setETag(c, updated)
+	render(c, http.StatusOK, updated)
+}
+
+// patchAlbum applies a JSON Merge Patch or JSON Patch, chosen by the
+// request Content-Type, to the album identified by the id parameter,
+// provided the If-Match header matches its current revision.
+func (s *server) patchAlbum(c *gin.Context) {
+	id := c.Param("id")
+
+	revs, ok := parseIfMatch(c)
+	if !ok {
+		return
+	}
+
+	var apply func(album, []byte) (album, error)
+	switch c.ContentType() {
+	case mergePatchType:
+		apply = mergePatchAlbum
+	case jsonPatchType:
+		apply = jsonPatchAlbum
+	default:
+		render(c, http.StatusUnsupportedMediaType, errorResponse{
+			Error:   "unsupported_media_type",
+			Message: fmt.Sprintf("Content-Type must be %s or %s", mergePatchType, jsonPatchType),
+		})
+		return
+	}
+
+	patch, err := c.GetRawData()
+	if err != nil {
+		render(c, http.StatusBadRequest, errorResponse{
+			Error:   "invalid_request",
+			Message: fmt.Sprintf("failed to read request body: %v", err),
+		})
+		return
+	}
+
+	current, err := s.store.Get(id)
+	if errors.Is(err, errAlbumNotFound) {
+		respondNotFound(c, id)
+		return
+	}
+	if err != nil {
+		respondStorageError(c, err)
+		return
+	}
+	if !ifMatchAllows(revs, current) {
+		respondPreconditionFailed(c, id)
+		return
+	}
+
+	patched, err := apply(current, patch)
+	switch {
+	case errors.Is(err, errPatchTouchesID):
+		render(c, http.StatusBadRequest, errorResponse{
+			Error:   "id_immutable",
+			Message: err.Error(),
+		})
+		return
+	case errors.Is(err, errInvalidPatch):
+		render(c, http.StatusBadRequest, errorResponse{
+			Error:   "invalid_patch",
+			Message: err.Error(),
+		})
+		return
+	case errors.Is(err, errPatchFailed):
+		render(c, http.StatusUnprocessableEntity, errorResponse{
+			Error:   "patch_failed",
+			Message: err.Error(),
+		})
+		return
+	case err != nil:
+		respondStorageError(c, err)
+		return
+	}
+
+	if err := validateAlbum(&patched); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	// Only write if nobody else has since the patch was applied
+	updated, err := s.store.Update(patched, current.revision())
+	switch {
+	case errors.Is(err, errAlbumNotFound):
+		respondNotFound(c, id)
+		return
+	case errors.Is(err, errVersionMismatch):
+		respondPreconditionFailed(c, id)
+		return
+	case err != nil:
+		respondStorageError(c, err)
+		return
+	}
+	setETag(c, updated)
+	render(c, http.StatusOK, updated)
+}
+
+// deleteAlbum moves the album identified by the id parameter to the
+// trash, or removes it permanently when the hard query parameter is true,
+// provided the If-Match header matches its current revision.
+func (s *server) deleteAlbum(c *gin.Context) {
+	id := c.Param("id")
+
+	hard, err := strconv.ParseBool(c.DefaultQuery("hard", "false"))
+	if err != nil {
+		render(c, http.StatusBadRequest, errorResponse{
+			Error:   "invalid_request",
+			Message: "hard must be true or false",
+		})
+		return
+	}
+
+	revs, ok := parseIfMatch(c)
+	if !ok {
+		return
+	}
+
+	err = withIfMatch(revs, func(ifRev revision) error {
+		if hard {
+			return s.store.Purge(id, ifRev)
+		}
+		_, err := s.store.Delete(id, ifRev)
+		return err
+	})
+	switch {
+	case errors.Is(err, errAlbumNotFound):
+		respondNotFound(c, id)
+		return
+	case errors.Is(err, errVersionMismatch):
+		respondPreconditionFailed(c, id)
+		return
+	case err != nil:
+		respondStorageError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// getTrash returns the soft-deleted albums in the negotiated format.
+func (s *server) getTrash(c *gin.Context) {
+	albums, err := s.store.Trash()
+	if err != nil {
+		respondStorageError(c, err)
+		return
+	}
+	render(c, http.StatusOK, albumList(albums))
+}
+
+// restoreAlbum moves the album identified by the id parameter out of the
+// trash.
+func (s *server) restoreAlbum(c *gin.Context) {
+	id := c.Param("id")
+
+	a, err := s.store.Restore(id)
+	if errors.Is(err, errAlbumNotFound) {
+		render(c, http.StatusNotFound, errorResponse{
+			Error:   "not_found",
+			Message: fmt.Sprintf("album with ID '%s' is not in the trash", id),
+		})
+		return
+	}
+	if err != nil {
+		respondStorageError(c, err)
+		return
+	}
+	setETag(c, a)
+	render(c, http.StatusOK, a)
+}
+
+// seedAlbums slice to seed record album data.
+var seedAlbums = []album{
+	{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Price: amount{5699, 2}, Currency: "USD"},
+	{ID: "2", Title: "Jeru", Artist: "Gerry Mulligan", Price: amount{1799, 2}, Currency: "USD"},
+	{ID: "3", Title: "Sarah Vaughan and Clifford Brown", Artist: "Sarah Vaughan", Price: amount{3999, 2}, Currency: "USD"},
+}
+
+// openStore builds the AlbumStore selected by cfg.Storage.
+func openStore(cfg config) (replicaStore, error) {
+	switch cfg.Storage {
+	case "memory":
+		return newMemoryStore(seedAlbums), nil
+	case "file":
+		return openFileStore(cfg.DataDir, seedAlbums)
+	case "s3":
+		if cfg.S3Bucket == "" {
+			return nil, errors.New("the s3 storage backend needs s3-bucket")
+		}
+		client, err := newS3Client(context.Background(), cfg.S3Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		return openS3Store(client, cfg.S3Bucket, s3Prefix(cfg.S3Prefix), seedAlbums)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q (want memory, file or s3)", cfg.Storage)
+	}
+}
+
+// app is the album service: the router serving it, and the parts that run
+// alongside it, which main starts and stops together with the server.
+type app struct {
+	router *gin.Engine
+	cl     *cluster
+	feed   *albumFeed
+	hooks  *webhookDispatcher
+}
+
+// newApp builds the album service configured by cfg around store, logging
+// requests to logger. Its metrics are kept in a registry of its own.
+func newApp(cfg config, store replicaStore, logger *slog.Logger) (*app, error) {
+	index := newSearchIndex()
+	indexed, err := newIndexedStore(store, index)
+	if err != nil {
+		return nil, fmt.Errorf("index albums: %w", err)
+	}
+	cl, err := newCluster(cfg.ClusterSelf, cfg.ClusterPeers, indexed)
+	if err != nil {
+		return nil, fmt.Errorf("join cluster: %w", err)
+	}
+	s := &server{store: cl.store, index: index}
+	auth, err := newAuthenticator(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("configure authentication: %w", err)
+	}
+	if !auth.enabled() {
+		logger.Warn("no API keys or bearer token keys configured; anyone can change albums")
+	}
+
+	limiter, err := newRateLimiter(cfg, cl)
+	if err != nil {
+		return nil, fmt.Errorf("configure rate limits: %w", err)
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	router := gin.New()
+	router.HandleMethodNotAllowed = true
+	router.NoRoute(noRoute)
+	router.NoMethod(noMethod)
+	access := &accessLog{log: logger, sample: cfg.AccessLogSample}
+	// Metrics wrap recovery so that requests ending in a panic are
+	// counted, as the 500s they are answered with.
+	router.Use(
+		access.handle,
+		newHTTPMetrics(reg).observe,
+		gin.CustomRecoveryWithWriter(io.Discard, recoverPanic),
+	)
+	proxies := append(strings.FieldsFunc(cfg.TrustedProxies, func(r rune) bool { return r == ',' || r == ' ' }), cl.peerIPs...)
+	if err := router.SetTrustedProxies(proxies); err != nil {
+		return nil, fmt.Errorf("trusted proxies: %w", err)
+	}
+
+	// Prometheus scrapes each instance without credentials or limits.
+	// The registry includes the Go runtime and process metrics.
+	router.GET("/metrics", gin.WrapH(promhttp.InstrumentMetricHandler(reg, promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))))
+
+	// Instances talk to each other without credentials or limits, and
+	// answer these themselves rather than forwarding to the leader. Only
+	// the instances' own addresses may call them.
+	internal := router.Group("/cluster", cl.peersOnly)
+	internal.GET("/changes", cl.getChanges)
+	internal.GET("/snapshot", cl.getSnapshot)
+	internal.POST("/ratelimit", limiter.takeToken)
+
+	router.Use(cl.forwardWrites, auth.authenticate, limiter.limit, auth.authorize)
+
+	// Operators read each instance's own status with the admin role.
+	router.GET("/cluster/status", cl.getStatus)
+
+	albums := router.Group("/albums", negotiate)
+	albums.GET("", s.getAlbums)
+	albums.GET("/trash", s.getTrash)
+	albums.GET("/search", s.searchAlbums)
+	albums.GET("/:id", s.getAlbumByID)
+	albums.POST("", s.postAlbums)
+	albums.PUT("/:id", s.putAlbum)
+	albums.PATCH("/:id", s.patchAlbum)
+	albums.DELETE("/:id", s.deleteAlbum)
+	albums.POST("/:id/restore", s.restoreAlbum)
+
+	// Custom methods hang off "/albums" itself, which a group would join
+	// with a slash. Export picks its own format instead of negotiating.
+	router.GET("/albums:verb", customMethods(map[string]gin.HandlerFunc{
+		":export": s.exportAlbums,
+	}))
+	router.POST("/albums:verb", negotiate, customMethods(map[string]gin.HandlerFunc{
+		":import": s.importAlbums,
+	}))
+
+	// The change feed streams instead of negotiating a format.
+	feed := newAlbumFeed(cl.store.log)
+	router.GET("/albums/events", feed.streamEvents)
+
+	// Webhooks are kept by the leader, and saved with the albums when
+	// those are kept in files.
+	var hooksFile string
+	if cfg.Storage == "file" {
+		hooksFile = filepath.Join(cfg.DataDir, webhooksFile)
+	}
+	hooks, err := newWebhookDispatcher(cl.store.log, cfg.WebhookAllowPrivate, hooksFile)
+	if err != nil {
+		return nil, fmt.Errorf("load webhooks: %w", err)
+	}
+	webhooks := router.Group("/webhooks", cl.leaderOnly)
+	webhooks.POST("", hooks.createWebhook)
+	webhooks.GET("", hooks.getWebhooks)
+	webhooks.GET("/dead-letters", hooks.getDeadLetters)
+	webhooks.POST("/dead-letters/:id/retry", hooks.retryDeadLetter)
+	webhooks.GET("/:id", hooks.getWebhook)
+	webhooks.DELETE("/:id", hooks.deleteWebhook)
+	webhooks.GET("/:id/deliveries", hooks.getDeliveries)
 
-	// Add the new album
-	albums = append(albums, newAlbum)
-	c.IndentedJSON(http.StatusCreated, newAlbum)
+	return &app{router: router, cl: cl, feed: feed, hooks: hooks}, nil
 }
 
-// albums slice to seed record album data.
-var albums = []album{
-	{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Price: 56.99},
-	{ID: "2", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99},
-	{ID: "3", Title: "Sarah Vaughan and Clifford Brown", Artist: "Sarah Vaughan", Price: 39.99},
+// start begins replicating from the leader and delivering webhooks.
+func (a *app) start() {
+	a.cl.start()
+	a.hooks.start()
 }
 
 func main() {
-	router := gin.Default()
-	router.GET("/albums", getAlbums)
-	router.GET("/albums/:id", getAlbumByID)
-	router.POST("/albums", postAlbums)
-	router.Run(":8080")
-}
\ No newline at end of file
+	cfg, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("load config: %v", err)
+	}
+	logger, err := newLogger(cfg.LogLevel)
+	if err != nil {
+		log.Fatalf("load config: %v", err)
+	}
+	// The log package writes through logger from here on.
+	slog.SetDefault(logger)
+	gin.SetMode(cfg.GinMode)
+
+	store, err := openStore(cfg)
+	if err != nil {
+		log.Fatalf("open album store: %v", err)
+	}
+	a, err := newApp(cfg, store, logger)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	srv := &http.Server{
+		Addr:         cfg.Addr,
+		Handler:      a.router,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	}
+	srv.RegisterOnShutdown(a.cl.shutdown)
+	srv.RegisterOnShutdown(a.feed.close)
+	srv.RegisterOnShutdown(a.hooks.shutdown)
+	a.start()
+	if err := serve(srv, cfg.ShutdownTimeout); err != nil {
+		log.Printf("server: %v", err)
+	}
+	a.cl.shutdown()
+	if c, ok := store.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Printf("close album store: %v", err)
+		}
+	}
+}
+
+// serve runs srv until it fails or the process receives SIGTERM or
+// SIGINT. It then stops accepting connections and waits up to drain for
+// in-flight requests to finish before closing the remaining connections.
+func serve(srv *http.Server, drain time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	errc := make(chan error, 1)
+	go func() {
+		log.Printf("listening on %s", srv.Addr)
+		errc <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	stop()
+
+	log.Printf("shutting down; draining requests for up to %s", drain)
+	ctx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		srv.Close()
+		return fmt.Errorf("drain: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// testConfig returns the default config without rate limits, which tests
+// that need them turn on.
+func testConfig() config {
+	cfg := defaultConfig
+	cfg.GinMode = gin.TestMode
+	cfg.RateLimitReads = 0
+	cfg.RateLimitWrites = 0
+	return cfg
+}
+
+// newTestApp builds an app from cfg around store, or a memory store
+// holding the seed albums if store is nil, as main would. It is stopped
+// when the test ends.
+func newTestApp(t *testing.T, cfg config, store replicaStore) *app {
+	t.Helper()
+	return newLoggingTestApp(t, cfg, store, io.Discard)
+}
+
+// newLoggingTestApp is newTestApp with the app's log written to w.
+func newLoggingTestApp(t *testing.T, cfg config, store replicaStore, w io.Writer) *app {
+	t.Helper()
+	if store == nil {
+		store = newMemoryStore(seedAlbums)
+	}
+	a, err := newApp(cfg, store, slog.New(slog.NewJSONHandler(w, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.start()
+	t.Cleanup(func() {
+		a.feed.close()
+		a.hooks.shutdown()
+		a.cl.shutdown()
+	})
+	return a
+}
+
+// send serves a request through h and returns the response. Header
+// names and values alternate in header. A body is sent as JSON unless a
+// Content-Type is given.
+func send(h http.Handler, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// decode parses the JSON body of a response into a T.
+func decode[T any](t *testing.T, w *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+		t.Fatalf("decode %s: %v", w.Body, err)
+	}
+	return v
+}
+
+// wantStatus fails the test unless w has the given status.
+func wantStatus(t *testing.T, w *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("got status %d, want %d: %s", w.Code, status, w.Body)
+	}
+}
+
+// wantError fails the test unless w is an errorResponse with the given
+// status and code.
+func wantError(t *testing.T, w *httptest.ResponseRecorder, status int, code string) errorResponse {
+	t.Helper()
+	wantStatus(t, w, status)
+	e := decode[errorResponse](t, w)
+	if e.Error != code {
+		t.Fatalf("got error %q, want %q: %s", e.Error, code, w.Body)
+	}
+	return e
+}
+
+func TestPutAlbum(t *testing.T) {
+	h := newTestApp(t, testConfig(), nil).router
+	const body = `{"title":"Blue Train (Remastered)","artist":"John Coltrane","price":"19.99"}`
+
+	tests := []struct {
+		name     string
+		target   string
+		body     string
+		ifMatch  string
+		status   int
+		wantCode string
+	}{
+		{"replaces", "/albums/1", body, `"1-1-json"`, http.StatusOK, ""},
+		{"unknown ID", "/albums/nope", body, "*", http.StatusNotFound, "not_found"},
+		{"ID mismatch", "/albums/1", `{"id":"2","title":"Jeru","artist":"Gerry Mulligan","price":17.99}`, "*", http.StatusBadRequest, "id_mismatch"},
+		{"invalid album", "/albums/1", `{"title":"","artist":"John Coltrane","price":-1}`, "*", http.StatusBadRequest, "validation_error"},
+		{"malformed body", "/albums/1", `{"title":`, "*", http.StatusBadRequest, "invalid_json"},
+		{"no If-Match", "/albums/1", body, "", http.StatusPreconditionRequired, "precondition_required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var header []string
+			if tt.ifMatch != "" {
+				header = []string{"If-Match", tt.ifMatch}
+			}
+			w := send(h, http.MethodPut, tt.target, tt.body, header...)
+			if tt.wantCode != "" {
+				wantError(t, w, tt.status, tt.wantCode)
+				return
+			}
+			wantStatus(t, w, tt.status)
+			a := decode[album](t, w)
+			if a.ID != "1" || a.Title != "Blue Train (Remastered)" || a.Price.String() != "19.99" || a.Version != 2 {
+				t.Errorf("got %+v", a)
+			}
+		})
+	}
+
+	// The replacement is what is read back.
+	a := decode[album](t, send(h, http.MethodGet, "/albums/1", ""))
+	if a.Title != "Blue Train (Remastered)" {
+		t.Errorf("read back %+v", a)
+	}
+}
+
+func TestPostAlbumReservedID(t *testing.T) {
+	h := newTestApp(t, testConfig(), nil).router
+
+	tests := []struct {
+		id   string
+		code string
+	}{
+		{"trash", "reserved"},
+		{"search", "reserved"},
+		{"events", "reserved"},
+		{".", "invalid_characters"},
+		{"..", "invalid_characters"},
+		{"...", "invalid_characters"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			w := send(h, http.MethodPost, "/albums", `{"id":"`+tt.id+`","title":"T","artist":"A","price":1}`)
+			e := wantError(t, w, http.StatusBadRequest, "validation_error")
+			if len(e.Details) != 1 || e.Details[0].Field != "id" || e.Details[0].Code != tt.code {
+				t.Errorf("got details %+v, want id %s", e.Details, tt.code)
+			}
+		})
+	}
+
+	// Names that only resemble a route are albums like any other.
+	w := send(h, http.MethodPost, "/albums", `{"id":"trash2","title":"T","artist":"A","price":1}`)
+	wantStatus(t, w, http.StatusCreated)
+	loc := w.Header().Get("Location")
+	if a := decode[album](t, send(h, http.MethodGet, loc, "")); a.ID != "trash2" {
+		t.Errorf("GET %s = %+v", loc, a)
+	}
+}
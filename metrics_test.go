@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMetricsCountPanics(t *testing.T) {
+	a := newTestApp(t, testConfig(), nil)
+	a.router.GET("/panic", func(c *gin.Context) { panic("boom") })
+
+	wantStatus(t, send(a.router, http.MethodGet, "/panic", ""), http.StatusInternalServerError)
+	wantStatus(t, send(a.router, http.MethodGet, "/albums/1", ""), http.StatusOK)
+	wantStatus(t, send(a.router, http.MethodGet, "/nowhere", ""), http.StatusNotFound)
+
+	w := send(a.router, http.MethodGet, "/metrics", "")
+	wantStatus(t, w, http.StatusOK)
+	for _, series := range []string{
+		`http_requests_total{method="GET",route="/panic",status="5xx"} 1`,
+		`http_requests_total{method="GET",route="/albums/:id",status="2xx"} 1`,
+		`http_requests_total{method="GET",route="unmatched",status="4xx"} 1`,
+		`http_request_duration_seconds_count{method="GET",route="/panic",status="5xx"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), series) {
+			t.Errorf("metrics lack %s", series)
+		}
+	}
+}
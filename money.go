@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// defaultCurrency is assumed for albums that do not name a currency,
+// including those stored before prices had one.
+const defaultCurrency = "USD"
+
+// maxAmountScale bounds the decimal places accepted in an amount.
+const maxAmountScale = 9
+
+// minorDigits maps the ISO 4217 currencies albums may be priced in to the
+// number of decimal digits of their minor unit.
+var minorDigits = map[string]int{
+	"AUD": 2, "BHD": 3, "BRL": 2, "CAD": 2, "CHF": 2, "CLP": 0, "CNY": 2,
+	"CZK": 2, "DKK": 2, "EUR": 2, "GBP": 2, "HKD": 2, "HUF": 2, "IDR": 2,
+	"ILS": 2, "INR": 2, "ISK": 0, "JOD": 3, "JPY": 0, "KRW": 0, "KWD": 3,
+	"MXN": 2, "MYR": 2, "NOK": 2, "NZD": 2, "OMR": 3, "PHP": 2, "PLN": 2,
+	"SEK": 2, "SGD": 2, "THB": 2, "TND": 3, "TRY": 2, "TWD": 2, "USD": 2,
+	"VND": 0, "ZAR": 2,
+}
+
+// defaultMaxPrice caps album prices, in whole units of their currency.
+const defaultMaxPrice = 100000
+
+// maxPrices overrides defaultMaxPrice for the currencies whose unit is
+// worth too little for it, with caps of about what defaultMaxPrice US
+// dollars are worth, rounded up to a power of ten.
+var maxPrices = map[string]int64{
+	"CLP": 1e8, "CZK": 1e7, "HUF": 1e8, "IDR": 1e10, "INR": 1e7, "ISK": 1e8,
+	"JPY": 1e8, "KRW": 1e9, "PHP": 1e7, "THB": 1e7, "TWD": 1e7, "VND": 1e10,
+}
+
+// maxPrice returns the highest album price allowed in currency, in whole
+// units.
+func maxPrice(currency string) int64 {
+	if m, ok := maxPrices[currency]; ok {
+		return m
+	}
+	return defaultMaxPrice
+}
+
+var (
+	errInvalidAmount = errors.New("must be a decimal number")
+	bigTen           = big.NewInt(10)
+)
+
+// amount is an exact decimal number, units × 10^-scale. Validated album
+// prices use the scale of their currency's minor unit, so units is the
+// price in minor units: 56.99 USD is {5699, 2} and 1500 JPY is {1500, 0}.
+//
+// Amounts are written as JSON numbers, and read from JSON numbers or
+// decimal strings, so 56.99 never passes through a float64.
+type amount struct {
+	units int64
+	scale int
+}
+
+// parseAmount parses a decimal number such as "56.99", "-3" or "1.5e2".
+func parseAmount(s string) (amount, error) {
+	mantissa, exp, hasExp := strings.Cut(s, "e")
+	if !hasExp {
+		mantissa, exp, hasExp = strings.Cut(s, "E")
+	}
+	whole, frac, _ := strings.Cut(mantissa, ".")
+	digits := strings.TrimLeft(whole, "+-")
+	if len(whole)-len(digits) > 1 || digits+frac == "" || !isDigits(digits) || !isDigits(frac) {
+		return amount{}, errInvalidAmount
+	}
+
+	units, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return amount{}, fmt.Errorf("%q is out of range", s)
+	}
+	a := amount{units: units, scale: len(frac)}
+	if hasExp {
+		e, err := strconv.Atoi(exp)
+		if err != nil {
+			return amount{}, errInvalidAmount
+		}
+		// An int64 holds at most 18 decimal digits.
+		if e > 18 || e < -maxAmountScale {
+			return amount{}, fmt.Errorf("%q is out of range", s)
+		}
+		a.scale -= e
+	}
+	if a.scale < 0 {
+		var ok bool
+		if a, ok = a.rescale(0); !ok {
+			return amount{}, fmt.Errorf("%q is out of range", s)
+		}
+	}
+	if a.scale > maxAmountScale {
+		return amount{}, fmt.Errorf("%q has more than %d decimal places", s, maxAmountScale)
+	}
+	return a, nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// rescale returns a with the given scale, reporting false if that would
+// drop nonzero digits or overflow.
+func (a amount) rescale(scale int) (amount, bool) {
+	units := big.NewInt(a.units)
+	for s := a.scale; s < scale; s++ {
+		units.Mul(units, bigTen)
+	}
+	for s := a.scale; s > scale; s-- {
+		var rem big.Int
+		if units.QuoRem(units, bigTen, &rem); rem.Sign() != 0 {
+			return a, false
+		}
+	}
+	if !units.IsInt64() {
+		return a, false
+	}
+	return amount{units: units.Int64(), scale: scale}, true
+}
+
+// cmp compares a and b by value, ignoring scale.
+func (a amount) cmp(b amount) int {
+	x, y := big.NewInt(a.units), big.NewInt(b.units)
+	for s := a.scale; s < b.scale; s++ {
+		x.Mul(x, bigTen)
+	}
+	for s := b.scale; s < a.scale; s++ {
+		y.Mul(y, bigTen)
+	}
+	return x.Cmp(y)
+}
+
+// sign returns -1, 0 or +1 depending on the sign of a.
+func (a amount) sign() int {
+	switch {
+	case a.units < 0:
+		return -1
+	case a.units > 0:
+		return 1
+	}
+	return 0
+}
+
+func (a amount) String() string {
+	s := strconv.FormatInt(a.units, 10)
+	if a.scale == 0 {
+		return s
+	}
+	sign, digits := "", s
+	if a.units < 0 {
+		sign, digits = "-", s[1:]
+	}
+	if pad := a.scale + 1 - len(digits); pad > 0 {
+		digits = strings.Repeat("0", pad) + digits
+	}
+	cut := len(digits) - a.scale
+	return sign + digits[:cut] + "." + digits[cut:]
+}
+
+func (a amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a string holding one.
+func (a *amount) UnmarshalJSON(b []byte) error {
+	if bytes.Equal(b, []byte("null")) {
+		return nil
+	}
+	s := string(b)
+	if b[0] == '"' {
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+	}
+	v, err := parseAmount(s)
+	if err != nil {
+		return fmt.Errorf("price %w", err)
+	}
+	*a = v
+	return nil
+}
+
+func (a amount) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *amount) UnmarshalText(b []byte) error {
+	v, err := parseAmount(strings.TrimSpace(string(b)))
+	if err != nil {
+		return fmt.Errorf("price %w", err)
+	}
+	*a = v
+	return nil
+}
+
+func (a amount) MarshalYAML() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalYAML accepts a YAML number or a quoted string holding one.
+func (a *amount) UnmarshalYAML(b []byte) error {
+	s := strings.TrimSpace(string(b))
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		s = s[1 : len(s)-1]
+	}
+	return a.UnmarshalText([]byte(s))
+}
+
+// upgradeLegacyPrice gives an album stored before prices had a currency
+// the default one, moving its price to minor units where that is exact.
+func upgradeLegacyPrice(a *album) {
+	if a.Currency != "" {
+		return
+	}
+	a.Currency = defaultCurrency
+	if p, ok := a.Price.rescale(minorDigits[defaultCurrency]); ok {
+		a.Price = p
+	}
+}
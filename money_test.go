@@ -0,0 +1,149 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    amount
+		wantErr string // substring of the error; empty for none
+	}{
+		{"56.99", amount{5699, 2}, ""},
+		{"1500", amount{1500, 0}, ""},
+		{"-3", amount{-3, 0}, ""},
+		{"+3", amount{3, 0}, ""},
+		{"0.5", amount{5, 1}, ""},
+		{".5", amount{5, 1}, ""},
+		{"5.", amount{5, 0}, ""},
+		{"1.50", amount{150, 2}, ""}, // trailing zeros keep their scale
+		{"007", amount{7, 0}, ""},
+
+		// Exponents move the decimal point; one that would leave a
+		// negative scale is folded into the units.
+		{"1.5e2", amount{150, 0}, ""},
+		{"1.5E2", amount{150, 0}, ""},
+		{"1e-2", amount{1, 2}, ""},
+		{"1.25e1", amount{125, 1}, ""},
+		{"1.234e+2", amount{1234, 1}, ""},
+		{"9e18", amount{9e18, 0}, ""},
+		{"9.3e18", amount{}, "out of range"},
+		{"1e19", amount{}, "out of range"},
+		{"1e-10", amount{}, "out of range"},
+		{"1e", amount{}, errInvalidAmount.Error()},
+
+		// Too many digits
+		{"0.123456789", amount{123456789, 9}, ""},
+		{"0.1234567891", amount{}, "more than 9 decimal places"},
+		{"1.23456789e-1", amount{123456789, 9}, ""},
+		{"1.23456789e-2", amount{}, "more than 9 decimal places"},
+		{"9223372036854775807", amount{9223372036854775807, 0}, ""},
+		{"9223372036854775808", amount{}, "out of range"},
+		{"92233720368547758.08", amount{}, "out of range"},
+
+		// Not numbers
+		{"", amount{}, errInvalidAmount.Error()},
+		{".", amount{}, errInvalidAmount.Error()},
+		{"--1", amount{}, errInvalidAmount.Error()},
+		{"1-", amount{}, errInvalidAmount.Error()},
+		{"1.2.3", amount{}, errInvalidAmount.Error()},
+		{"1,50", amount{}, errInvalidAmount.Error()},
+		{"0x10", amount{}, errInvalidAmount.Error()},
+		{"NaN", amount{}, errInvalidAmount.Error()},
+	}
+	for _, tt := range tests {
+		got, err := parseAmount(tt.in)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseAmount(%q) error = %v, want one containing %q", tt.in, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseAmount(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestRescale(t *testing.T) {
+	tests := []struct {
+		a      amount
+		scale  int
+		want   amount
+		wantOK bool
+	}{
+		{amount{5699, 2}, 2, amount{5699, 2}, true},
+		{amount{15, 1}, 3, amount{1500, 3}, true},
+		{amount{1500, 3}, 0, amount{}, false}, // 1.5 JPY
+		{amount{1000, 3}, 0, amount{1, 0}, true},
+		{amount{-2500, 3}, 2, amount{-250, 2}, true},
+		{amount{12345, 4}, 2, amount{}, false}, // dropping digits never rounds
+		{amount{9223372036854775807, 0}, 1, amount{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := tt.a.rescale(tt.scale)
+		if ok != tt.wantOK || (ok && got != tt.want) {
+			t.Errorf("%v.rescale(%d) = %v, %v, want %v, %v", tt.a, tt.scale, got, ok, tt.want, tt.wantOK)
+		}
+		if !ok && got != tt.a {
+			t.Errorf("%v.rescale(%d) changed the amount to %v on failure", tt.a, tt.scale, got)
+		}
+	}
+}
+
+func TestAmountString(t *testing.T) {
+	for _, s := range []string{"56.99", "1500", "-3", "0.05", "-0.05", "1.50", "0.000000001"} {
+		a, err := parseAmount(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := a.String(); got != s {
+			t.Errorf("parseAmount(%q).String() = %q", s, got)
+		}
+	}
+}
+
+func TestValidatePrice(t *testing.T) {
+	tests := []struct {
+		currency string
+		price    string
+		want     amount
+		wantCode string
+	}{
+		{"USD", "56.99", amount{5699, 2}, ""},
+		{"usd", "5", amount{500, 2}, ""},
+		{"JPY", "1500", amount{1500, 0}, ""},
+		{"JPY", "1500.5", amount{}, "too_precise"},
+		{"BHD", "1.005", amount{1005, 3}, ""},
+		{"USD", "1.005", amount{}, "too_precise"},
+		{"USD", "100000", amount{10000000, 2}, ""},
+		{"USD", "100000.01", amount{}, "too_large"},
+		{"VND", "450000", amount{450000, 0}, ""},
+		{"IDR", "250000.50", amount{25000050, 2}, ""},
+		{"KRW", "1000000001", amount{}, "too_large"},
+		{"USD", "0", amount{}, "must_be_positive"},
+		{"USD", "-1", amount{}, "must_be_positive"},
+	}
+	for _, tt := range tests {
+		price, err := parseAmount(tt.price)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a := album{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Price: price, Currency: tt.currency}
+		err = validateAlbum(&a)
+		var code string
+		if errs := fieldErrors(err); len(errs) > 0 {
+			code = errs[0].Code
+		} else if err != nil {
+			t.Fatalf("%s %s: %v", tt.price, tt.currency, err)
+		}
+		if code != tt.wantCode {
+			t.Errorf("%s %s: got code %q, want %q", tt.price, tt.currency, code, tt.wantCode)
+		}
+		if code == "" && a.Price != tt.want {
+			t.Errorf("%s %s: got price %v, want %v", tt.price, tt.currency, a.Price, tt.want)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+var (
+	// errPatchTouchesID is returned for patches that would change an album's ID.
+	errPatchTouchesID = errors.New("patch must not modify the album ID")
+
+	// errInvalidPatch wraps patch documents that are malformed.
+	errInvalidPatch = errors.New("invalid patch document")
+
+	// errPatchFailed wraps well-formed patches that cannot be applied to
+	// the current album, such as a failed test op or a missing path.
+	errPatchFailed = errors.New("patch could not be applied")
+)
+
+// patchOp is a single RFC 6902 operation.
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  *string         `json:"path"`
+	From  *string         `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"` // nil when absent, "null" for null
+}
+
+// mergePatchAlbum applies an RFC 7396 JSON Merge Patch to a.
+func mergePatchAlbum(a album, patch []byte) (album, error) {
+	var p any
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return album{}, fmt.Errorf("%w: %v", errInvalidPatch, err)
+	}
+	if obj, ok := p.(map[string]any); ok {
+		if _, ok := obj["id"]; ok {
+			return album{}, errPatchTouchesID
+		}
+	} else {
+		// A non-object patch replaces the whole document.
+		return album{}, fmt.Errorf("%w: merge patch must be a JSON object", errPatchFailed)
+	}
+
+	doc, err := albumToDoc(a)
+	if err != nil {
+		return album{}, err
+	}
+	return docToAlbum(mergePatch(doc, p))
+}
+
+// mergePatch implements the RFC 7396 MergePatch algorithm.
+func mergePatch(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = map[string]any{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
+
+// jsonPatchAlbum applies an RFC 6902 JSON Patch to a. Operations are
+// applied in order and the patch fails as a whole if any one fails.
+func jsonPatchAlbum(a album, patch []byte) (album, error) {
+	var ops []patchOp
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return album{}, fmt.Errorf("%w: %v", errInvalidPatch, err)
+	}
+
+	for i, op := range ops {
+		if op.Path == nil {
+			return album{}, fmt.Errorf("%w: operation %d is missing path", errInvalidPatch, i)
+		}
+		// A test only reads the ID, so it may name it.
+		if (op.Op != "test" && touchesID(*op.Path)) || (op.Op == "move" && op.From != nil && touchesID(*op.From)) {
+			return album{}, errPatchTouchesID
+		}
+	}
+
+	doc, err := albumToDoc(a)
+	if err != nil {
+		return album{}, err
+	}
+	for i, op := range ops {
+		if doc, err = applyPatchOp(doc, op); err != nil {
+			return album{}, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, *op.Path, err)
+		}
+	}
+	return docToAlbum(doc)
+}
+
+// touchesID reports whether the JSON Pointer ptr addresses the album ID
+// or the whole document.
+func touchesID(ptr string) bool {
+	return ptr == "" || ptr == "/id" || strings.HasPrefix(ptr, "/id/")
+}
+
+func applyPatchOp(doc any, op patchOp) (any, error) {
+	path, err := parsePointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	value := func() (any, error) {
+		if op.Value == nil {
+			return nil, fmt.Errorf("%w: missing value", errInvalidPatch)
+		}
+		var v any
+		if err := json.Unmarshal(op.Value, &v); err != nil {
+			return nil, fmt.Errorf("%w: %v", errInvalidPatch, err)
+		}
+		return v, nil
+	}
+	from := func() ([]string, error) {
+		if op.From == nil {
+			return nil, fmt.Errorf("%w: missing from", errInvalidPatch)
+		}
+		return parsePointer(*op.From)
+	}
+
+	switch op.Op {
+	case "add":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, v)
+	case "remove":
+		doc, _, err := pointerRemove(doc, path)
+		return doc, err
+	case "replace":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		if doc, _, err = pointerRemove(doc, path); err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, v)
+	case "move":
+		src, err := from()
+		if err != nil {
+			return nil, err
+		}
+		if len(path) > len(src) && reflect.DeepEqual(path[:len(src)], src) {
+			return nil, fmt.Errorf("%w: cannot move a value into one of its children", errPatchFailed)
+		}
+		doc, v, err := pointerRemove(doc, src)
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, v)
+	case "copy":
+		src, err := from()
+		if err != nil {
+			return nil, err
+		}
+		v, err := pointerGet(doc, src)
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, deepCopy(v))
+	case "test":
+		want, err := value()
+		if err != nil {
+			return nil, err
+		}
+		got, err := pointerGet(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(got, want) {
+			return nil, fmt.Errorf("%w: test failed", errPatchFailed)
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown op %q", errInvalidPatch, op.Op)
+	}
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into unescaped tokens.
+func parsePointer(ptr string) ([]string, error) {
+	if ptr == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(ptr, "/") {
+		return nil, fmt.Errorf("%w: pointer %q must start with /", errInvalidPatch, ptr)
+	}
+	tokens := strings.Split(ptr[1:], "/")
+	for i, t := range tokens {
+		if strings.Contains(strings.NewReplacer("~0", "", "~1", "").Replace(t), "~") {
+			return nil, fmt.Errorf("%w: pointer %q has a ~ not followed by 0 or 1", errInvalidPatch, ptr)
+		}
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+	}
+	return tokens, nil
+}
+
+// arrayIndex resolves token against an array of length n. When appending
+// is true the index may equal n, and "-" refers to the end of the array.
+func arrayIndex(token string, n int, appending bool) (int, error) {
+	if appending && token == "-" {
+		return n, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("%w: invalid array index %q", errPatchFailed, token)
+	}
+	if i > n || (i == n && !appending) {
+		return 0, fmt.Errorf("%w: array index %d out of range", errPatchFailed, i)
+	}
+	return i, nil
+}
+
+func pointerGet(doc any, path []string) (any, error) {
+	for _, t := range path {
+		switch n := doc.(type) {
+		case map[string]any:
+			v, ok := n[t]
+			if !ok {
+				return nil, fmt.Errorf("%w: path %q does not exist", errPatchFailed, t)
+			}
+			doc = v
+		case []any:
+			i, err := arrayIndex(t, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = n[i]
+		default:
+			return nil, fmt.Errorf("%w: path %q does not exist", errPatchFailed, t)
+		}
+	}
+	return doc, nil
+}
+
+// pointerAdd returns doc with v added at path.
+func pointerAdd(doc any, path []string, v any) (any, error) {
+	if len(path) == 0 {
+		return v, nil
+	}
+	t, rest := path[0], path[1:]
+	switch n := doc.(type) {
+	case map[string]any:
+		if len(rest) == 0 {
+			n[t] = v
+			return n, nil
+		}
+		child, ok := n[t]
+		if !ok {
+			return nil, fmt.Errorf("%w: path %q does not exist", errPatchFailed, t)
+		}
+		child, err := pointerAdd(child, rest, v)
+		if err != nil {
+			return nil, err
+		}
+		n[t] = child
+		return n, nil
+	case []any:
+		i, err := arrayIndex(t, len(n), len(rest) == 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) == 0 {
+			return append(n[:i], append([]any{v}, n[i:]...)...), nil
+		}
+		child, err := pointerAdd(n[i], rest, v)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = child
+		return n, nil
+	default:
+		return nil, fmt.Errorf("%w: path %q does not exist", errPatchFailed, t)
+	}
+}
+
+// pointerRemove returns doc without the value at path, and that value.
+func pointerRemove(doc any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, doc, nil
+	}
+	t, rest := path[0], path[1:]
+	switch n := doc.(type) {
+	case map[string]any:
+		child, ok := n[t]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: path %q does not exist", errPatchFailed, t)
+		}
+		if len(rest) == 0 {
+			delete(n, t)
+			return n, child, nil
+		}
+		child, removed, err := pointerRemove(child, rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		n[t] = child
+		return n, removed, nil
+	case []any:
+		i, err := arrayIndex(t, len(n), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(rest) == 0 {
+			removed := n[i]
+			return append(n[:i], n[i+1:]...), removed, nil
+		}
+		child, removed, err := pointerRemove(n[i], rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		n[i] = child
+		return n, removed, nil
+	default:
+		return nil, nil, fmt.Errorf("%w: path %q does not exist", errPatchFailed, t)
+	}
+}
+
+func deepCopy(v any) any {
+	b, _ := json.Marshal(v)
+	var out any
+	json.Unmarshal(b, &out)
+	return out
+}
+
+// albumToDoc converts a into its generic JSON representation.
+func albumToDoc(a album) (any, error) {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	var doc any
+	err = json.Unmarshal(b, &doc)
+	return doc, err
+}
+
+// docToAlbum converts a patched document back into an album, rejecting
+// fields the album does not have.
+func docToAlbum(doc any) (album, error) {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return album{}, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	var a album
+	if err := dec.Decode(&a); err != nil {
+		return album{}, fmt.Errorf("%w: result is not a valid album: %v", errPatchFailed, err)
+	}
+	return a, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// decodeJSON decodes s, failing the test if it is not valid JSON.
+func decodeJSON(t *testing.T, s string) any {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("bad JSON %s: %v", s, err)
+	}
+	return v
+}
+
+func TestApplyPatchOp(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		patch   string
+		want    string // result document when wantErr is nil
+		wantErr error
+	}{
+		// Pointer escapes
+		{"tilde one is a slash", `{"a/b":1}`, `[{"op":"replace","path":"/a~1b","value":2}]`, `{"a/b":2}`, nil},
+		{"tilde zero is a tilde", `{"m~n":1}`, `[{"op":"remove","path":"/m~0n"}]`, `{}`, nil},
+		{"tilde zero one is tilde one", `{"~1":1,"/":2}`, `[{"op":"remove","path":"/~01"}]`, `{"/":2}`, nil},
+		{"empty key", `{"":1}`, `[{"op":"test","path":"/","value":1}]`, `{"":1}`, nil},
+		{"bad escape", `{"~2":1}`, `[{"op":"remove","path":"/~2"}]`, "", errInvalidPatch},
+		{"trailing tilde", `{"a~":1}`, `[{"op":"remove","path":"/a~"}]`, "", errInvalidPatch},
+		{"pointer without slash", `{"a":1}`, `[{"op":"remove","path":"a"}]`, "", errInvalidPatch},
+
+		// Array indices
+		{"insert before index", `{"a":[1,3]}`, `[{"op":"add","path":"/a/1","value":2}]`, `{"a":[1,2,3]}`, nil},
+		{"insert at length", `{"a":[1]}`, `[{"op":"add","path":"/a/1","value":2}]`, `{"a":[1,2]}`, nil},
+		{"append with dash", `{"a":[1]}`, `[{"op":"add","path":"/a/-","value":2}]`, `{"a":[1,2]}`, nil},
+		{"insert past length", `{"a":[1]}`, `[{"op":"add","path":"/a/2","value":2}]`, "", errPatchFailed},
+		{"remove element", `{"a":[1,2,3]}`, `[{"op":"remove","path":"/a/1"}]`, `{"a":[1,3]}`, nil},
+		{"remove at length", `{"a":[1]}`, `[{"op":"remove","path":"/a/1"}]`, "", errPatchFailed},
+		{"remove dash", `{"a":[1]}`, `[{"op":"remove","path":"/a/-"}]`, "", errPatchFailed},
+		{"replace dash", `{"a":[1]}`, `[{"op":"replace","path":"/a/-","value":2}]`, "", errPatchFailed},
+		{"leading zero", `{"a":[1,2]}`, `[{"op":"remove","path":"/a/01"}]`, "", errPatchFailed},
+		{"negative index", `{"a":[1,2]}`, `[{"op":"remove","path":"/a/-1"}]`, "", errPatchFailed},
+		{"nested element", `{"a":[{"b":1}]}`, `[{"op":"replace","path":"/a/0/b","value":2}]`, `{"a":[{"b":2}]}`, nil},
+
+		// Test
+		{"test passes", `{"a":{"b":[1,"x"]}}`, `[{"op":"test","path":"/a","value":{"b":[1,"x"]}}]`, `{"a":{"b":[1,"x"]}}`, nil},
+		{"test fails", `{"a":1}`, `[{"op":"test","path":"/a","value":2}]`, "", errPatchFailed},
+		{"test of missing path", `{"a":1}`, `[{"op":"test","path":"/b","value":1}]`, "", errPatchFailed},
+		{"test without value", `{"a":1}`, `[{"op":"test","path":"/a"}]`, "", errInvalidPatch},
+
+		// Move
+		{"move member", `{"a":1}`, `[{"op":"move","from":"/a","path":"/b"}]`, `{"b":1}`, nil},
+		{"move onto itself", `{"a":1}`, `[{"op":"move","from":"/a","path":"/a"}]`, `{"a":1}`, nil},
+		{"move into own child", `{"a":{"b":1}}`, `[{"op":"move","from":"/a","path":"/a/b/c"}]`, "", errPatchFailed},
+		{"move to sibling with shared prefix", `{"a":1,"ab":2}`, `[{"op":"move","from":"/a","path":"/ab"}]`, `{"ab":1}`, nil},
+		{"move element to end", `{"a":[1,2,3]}`, `[{"op":"move","from":"/a/0","path":"/a/-"}]`, `{"a":[2,3,1]}`, nil},
+		{"move missing", `{"a":1}`, `[{"op":"move","from":"/b","path":"/c"}]`, "", errPatchFailed},
+		{"move without from", `{"a":1}`, `[{"op":"move","path":"/c"}]`, "", errInvalidPatch},
+
+		// Copy
+		{"copy member", `{"a":1}`, `[{"op":"copy","from":"/a","path":"/b"}]`, `{"a":1,"b":1}`, nil},
+		{"copy is deep", `{"a":{"x":1}}`, `[{"op":"copy","from":"/a","path":"/b"},{"op":"add","path":"/b/y","value":2}]`, `{"a":{"x":1},"b":{"x":1,"y":2}}`, nil},
+		{"copy into array", `{"a":[1,2]}`, `[{"op":"copy","from":"/a/1","path":"/a/0"}]`, `{"a":[2,1,2]}`, nil},
+		{"copy missing", `{"a":1}`, `[{"op":"copy","from":"/b","path":"/c"}]`, "", errPatchFailed},
+
+		// Other ops
+		{"add to missing parent", `{}`, `[{"op":"add","path":"/a/b","value":1}]`, "", errPatchFailed},
+		{"replace missing", `{}`, `[{"op":"replace","path":"/a","value":1}]`, "", errPatchFailed},
+		{"replace root", `{"a":1}`, `[{"op":"replace","path":"","value":{"b":2}}]`, `{"b":2}`, nil},
+		{"add null value", `{}`, `[{"op":"add","path":"/a","value":null}]`, `{"a":null}`, nil},
+		{"unknown op", `{}`, `[{"op":"frobnicate","path":"/a"}]`, "", errInvalidPatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ops []patchOp
+			if err := json.Unmarshal([]byte(tt.patch), &ops); err != nil {
+				t.Fatalf("bad patch: %v", err)
+			}
+			doc := decodeJSON(t, tt.doc)
+			var err error
+			for _, op := range ops {
+				if doc, err = applyPatchOp(doc, op); err != nil {
+					break
+				}
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if want := decodeJSON(t, tt.want); !reflect.DeepEqual(doc, want) {
+				t.Errorf("got %v, want %v", doc, want)
+			}
+		})
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	// Cases from RFC 7396, appendix A.
+	tests := []struct {
+		target, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		got := mergePatch(decodeJSON(t, tt.target), decodeJSON(t, tt.patch))
+		if want := decodeJSON(t, tt.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("mergePatch(%s, %s) = %v, want %v", tt.target, tt.patch, got, want)
+		}
+	}
+}
+
+func TestPatchAlbumID(t *testing.T) {
+	a := album{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Price: amount{5699, 2}, Currency: "USD", Version: 3}
+	tests := []struct {
+		name    string
+		apply   func(album, []byte) (album, error)
+		patch   string
+		wantErr error
+	}{
+		{"merge sets id", mergePatchAlbum, `{"id":"2"}`, errPatchTouchesID},
+		{"merge removes id", mergePatchAlbum, `{"id":null}`, errPatchTouchesID},
+		{"merge removes title", mergePatchAlbum, `{"title":null}`, nil},
+		{"test id", jsonPatchAlbum, `[{"op":"test","path":"/id","value":"1"},{"op":"replace","path":"/title","value":"Jeru"}]`, nil},
+		{"test wrong id", jsonPatchAlbum, `[{"op":"test","path":"/id","value":"2"}]`, errPatchFailed},
+		{"replace id", jsonPatchAlbum, `[{"op":"replace","path":"/id","value":"2"}]`, errPatchTouchesID},
+		{"remove id", jsonPatchAlbum, `[{"op":"remove","path":"/id"}]`, errPatchTouchesID},
+		{"move id", jsonPatchAlbum, `[{"op":"move","from":"/id","path":"/title"}]`, errPatchTouchesID},
+		{"copy onto id", jsonPatchAlbum, `[{"op":"copy","from":"/title","path":"/id"}]`, errPatchTouchesID},
+		{"replace root", jsonPatchAlbum, `[{"op":"replace","path":"","value":{}}]`, errPatchTouchesID},
+		{"unknown field", jsonPatchAlbum, `[{"op":"add","path":"/label","value":"Blue Note"}]`, errPatchFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.apply(a, []byte(tt.patch))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got.ID != a.ID {
+				t.Errorf("got ID %q, want %q", got.ID, a.ID)
+			}
+		})
+	}
+}
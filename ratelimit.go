@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// rateLimitSweep is how often buckets that have refilled are dropped.
+	rateLimitSweep = time.Minute
+
+	// rateLimitLeaderTimeout bounds how long a follower waits for the
+	// leader's buckets in shared mode before using its own.
+	rateLimitLeaderTimeout = 500 * time.Millisecond
+)
+
+// Request classes, each with its own budget.
+const (
+	classRead  = "read"
+	classWrite = "write"
+)
+
+// rateBudget is a token bucket's refill rate, in requests per second, and
+// size. A zero rate means no limit.
+type rateBudget struct {
+	rate  float64
+	burst int
+}
+
+// window is how long an empty bucket takes to fill.
+func (b rateBudget) window() int {
+	return ceilSeconds(float64(b.burst) / b.rate)
+}
+
+// tokenBucket holds a client's tokens as of updated.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+type bucketKey struct {
+	class  string
+	client string
+}
+
+// rateDecision is the outcome of taking a token from a bucket.
+type rateDecision struct {
+	Allowed    bool `json:"allowed"`
+	Limit      int  `json:"limit"`
+	Remaining  int  `json:"remaining"`
+	Reset      int  `json:"reset"`       // seconds until the bucket is full
+	RetryAfter int  `json:"retry_after"` // seconds until a token is available
+}
+
+// takeRequest asks the leader for a token in shared mode.
+type takeRequest struct {
+	Class  string `json:"class"`
+	Client string `json:"client"`
+}
+
+// rateLimiter limits the requests of each client, as named by
+// rateLimitClient, with one token bucket per client and request class.
+type rateLimiter struct {
+	budgets map[string]rateBudget // by request class
+	cl      *cluster              // set to use the leader's buckets
+
+	mu      sync.Mutex
+	buckets map[bucketKey]*tokenBucket
+	swept   time.Time
+	warned  time.Time // last logged failure to reach the leader
+}
+
+// newRateLimiter builds the rate limiter configured by cfg. With shared
+// set, a follower takes its tokens from the leader's buckets, so that a
+// client's budget holds across the cluster.
+func newRateLimiter(cfg config, cl *cluster) (*rateLimiter, error) {
+	l := &rateLimiter{
+		budgets: map[string]rateBudget{
+			classRead:  {cfg.RateLimitReads, cfg.RateLimitReadBurst},
+			classWrite: {cfg.RateLimitWrites, cfg.RateLimitWriteBurst},
+		},
+		buckets: make(map[bucketKey]*tokenBucket),
+	}
+	for class, b := range l.budgets {
+		if b.rate > 0 && b.burst < 1 {
+			return nil, fmt.Errorf("the %s rate limit needs a burst of at least 1", class)
+		}
+	}
+	if cfg.RateLimitShared && cl.role() == roleFollower {
+		l.cl = cl
+	}
+	return l, nil
+}
+
+// limit rejects the request with 429 Too Many Requests once its client
+// has used up the budget of its class, and reports the budget in the
+// RateLimit-* headers either way.
+func (l *rateLimiter) limit(c *gin.Context) {
+	class := classWrite
+	if isReadMethod(c.Request.Method) {
+		class = classRead
+	}
+	b := l.budgets[class]
+	if b.rate == 0 {
+		c.Next()
+		return
+	}
+
+	d := l.decide(c.Request.Context(), class, rateLimitClient(c))
+	c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", b.burst, b.window()))
+	c.Header("RateLimit-Limit", strconv.Itoa(d.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(d.Reset))
+	if !d.Allowed {
+		c.Header("Retry-After", strconv.Itoa(d.RetryAfter))
+		render(c, http.StatusTooManyRequests, errorResponse{
+			Error:   "rate_limited",
+			Message: fmt.Sprintf("too many %s requests; retry in %ds", class, d.RetryAfter),
+		})
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// rateLimitClient names the client a request counts against: the caller
+// it authenticated as, or else, as when its credentials were rejected,
+// its IP address.
+func rateLimitClient(c *gin.Context) string {
+	if v, ok := c.Get(principalKey); ok {
+		p := v.(principal)
+		return p.Method + ":" + p.Name
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// decide takes a token for client from the leader's buckets in shared
+// mode, falling back to this instance's own when the leader cannot be
+// reached, and from this instance's otherwise.
+func (l *rateLimiter) decide(ctx context.Context, class, client string) rateDecision {
+	if l.cl != nil {
+		d, err := l.askLeader(ctx, class, client)
+		if err == nil {
+			return d
+		}
+		l.mu.Lock()
+		if time.Since(l.warned) >= rateLimitSweep {
+			l.warned = time.Now()
+			log.Printf("rate limit: using local buckets: %v", err)
+		}
+		l.mu.Unlock()
+	}
+	return l.take(class, client, time.Now())
+}
+
+// take takes a token from client's bucket for class at time now.
+func (l *rateLimiter) take(class, client string, now time.Time) rateDecision {
+	b := l.budgets[class]
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	k := bucketKey{class, client}
+	tb := l.buckets[k]
+	if tb == nil {
+		tb = &tokenBucket{tokens: float64(b.burst), updated: now}
+		l.buckets[k] = tb
+	}
+	tb.tokens = min(float64(b.burst), tb.tokens+now.Sub(tb.updated).Seconds()*b.rate)
+	tb.updated = now
+
+	d := rateDecision{Limit: b.burst}
+	if tb.tokens >= 1 {
+		tb.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = ceilSeconds((1 - tb.tokens) / b.rate)
+	}
+	d.Remaining = int(tb.tokens)
+	d.Reset = ceilSeconds((float64(b.burst) - tb.tokens) / b.rate)
+	return d
+}
+
+// sweep drops the buckets that have refilled since they were last used,
+// which a new bucket would equal. It runs at most once per
+// rateLimitSweep.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < rateLimitSweep {
+		return
+	}
+	l.swept = now
+	for k, tb := range l.buckets {
+		b := l.budgets[k.class]
+		if tb.tokens+now.Sub(tb.updated).Seconds()*b.rate >= float64(b.burst) {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// askLeader takes a token for client from the leader's buckets.
+func (l *rateLimiter) askLeader(ctx context.Context, class, client string) (rateDecision, error) {
+	var d rateDecision
+	body, err := json.Marshal(takeRequest{Class: class, Client: client})
+	if err != nil {
+		return d, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, rateLimitLeaderTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.cl.leader+"/cluster/ratelimit", bytes.NewReader(body))
+	if err != nil {
+		return d, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := l.cl.client.Do(req)
+	if err != nil {
+		return d, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return d, fmt.Errorf("leader answered %s", resp.Status)
+	}
+	return d, json.NewDecoder(resp.Body).Decode(&d)
+}
+
+// takeToken takes a token from the bucket a follower names, for followers
+// sharing this instance's buckets.
+func (l *rateLimiter) takeToken(c *gin.Context) {
+	var r takeRequest
+	err := c.ShouldBindJSON(&r)
+	if err == nil && r.Class != classRead && r.Class != classWrite {
+		err = errors.New("class must be read or write")
+	}
+	if err != nil {
+		render(c, http.StatusBadRequest, errorResponse{
+			Error:   "invalid_request",
+			Message: err.Error(),
+		})
+		return
+	}
+	if l.budgets[r.Class].rate == 0 {
+		c.JSON(http.StatusOK, rateDecision{Allowed: true})
+		return
+	}
+	c.JSON(http.StatusOK, l.take(r.Class, r.Client, time.Now()))
+}
+
+// ceilSeconds rounds secs up to whole seconds.
+func ceilSeconds(secs float64) int {
+	return int(math.Ceil(secs))
+}
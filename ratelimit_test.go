@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// wantRateLimit fails the test unless w reports remaining requests left
+// of limit in its RateLimit-* headers.
+func wantRateLimit(t *testing.T, w *httptest.ResponseRecorder, limit, remaining int) {
+	t.Helper()
+	if got := w.Header().Get("RateLimit-Limit"); got != strconv.Itoa(limit) {
+		t.Errorf("got RateLimit-Limit %q, want %d", got, limit)
+	}
+	if got := w.Header().Get("RateLimit-Remaining"); got != strconv.Itoa(remaining) {
+		t.Errorf("got RateLimit-Remaining %q, want %d", got, remaining)
+	}
+	if got := w.Header().Get("RateLimit-Reset"); got == "" {
+		t.Error("no RateLimit-Reset header")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	cfg := testConfig()
+	cfg.RateLimitReads, cfg.RateLimitReadBurst = 0.5, 2
+	cfg.RateLimitWrites, cfg.RateLimitWriteBurst = 0.5, 1
+	h := newTestApp(t, cfg, nil).router
+
+	for remaining := 1; remaining >= 0; remaining-- {
+		w := send(h, http.MethodGet, "/albums/1", "")
+		wantStatus(t, w, http.StatusOK)
+		wantRateLimit(t, w, 2, remaining)
+		if got := w.Header().Get("RateLimit-Policy"); got != "2;w=4" {
+			t.Errorf("got RateLimit-Policy %q, want 2;w=4", got)
+		}
+	}
+
+	w := send(h, http.MethodGet, "/albums/1", "")
+	wantError(t, w, http.StatusTooManyRequests, "rate_limited")
+	wantRateLimit(t, w, 2, 0)
+	if got, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || got < 1 || got > 2 {
+		t.Errorf("got Retry-After %q, want 1 or 2 seconds", w.Header().Get("Retry-After"))
+	}
+
+	// Writes have a budget of their own.
+	w = send(h, http.MethodPost, "/albums", `{"title":"T","artist":"A","price":1}`)
+	wantStatus(t, w, http.StatusCreated)
+	wantRateLimit(t, w, 1, 0)
+
+	// Only trusted proxies may name another client.
+	w = send(h, http.MethodGet, "/albums/1", "", "X-Forwarded-For", "203.0.113.9")
+	wantStatus(t, w, http.StatusTooManyRequests)
+
+	// Other clients are not held back.
+	r := httptest.NewRequest(http.MethodGet, "/albums/1", nil)
+	r.RemoteAddr = "203.0.113.9:4321"
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	wantStatus(t, w, http.StatusOK)
+}
+
+func TestRateLimitFailedAuth(t *testing.T) {
+	cfg := testConfig()
+	cfg.AuthAPIKeys = "ci:viewer:" + hashKey("viewer-key")
+	cfg.RateLimitReads, cfg.RateLimitReadBurst = 0.5, 2
+	h := newTestApp(t, cfg, nil).router
+
+	// Wrong keys count against the address they come from.
+	for range 2 {
+		wantError(t, send(h, http.MethodGet, "/albums/1", "", "X-API-Key", "guess"), http.StatusUnauthorized, "unauthorized")
+	}
+	w := send(h, http.MethodGet, "/albums/1", "", "X-API-Key", "guess")
+	wantError(t, w, http.StatusTooManyRequests, "rate_limited")
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+
+	// The key's own budget is untouched.
+	w = send(h, http.MethodGet, "/albums/1", "", "X-API-Key", "viewer-key")
+	wantStatus(t, w, http.StatusOK)
+	wantRateLimit(t, w, 2, 1)
+
+	// A wrong key on a route that does not exist is still refused.
+	r := httptest.NewRequest(http.MethodGet, "/nowhere", nil)
+	r.RemoteAddr = "203.0.113.9:4321"
+	r.Header.Set("X-API-Key", "guess")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	wantError(t, w, http.StatusUnauthorized, "unauthorized")
+}
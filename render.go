@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+const (
+	mimeCSV = "text/csv"
+
+	// formatKey is the context key holding the negotiated response type.
+	formatKey = "format"
+)
+
+// offeredFormats are the response types the album endpoints can render,
+// in order of preference when the client accepts several.
+var offeredFormats = []string{
+	binding.MIMEJSON,
+	binding.MIMEXML,
+	binding.MIMEXML2,
+	binding.MIMEYAML2,
+	binding.MIMEYAML,
+	mimeCSV,
+}
+
+// formatName returns the short name of a response type from
+// offeredFormats, or "json" for any other.
+func formatName(mime string) string {
+	switch mime {
+	case binding.MIMEXML, binding.MIMEXML2:
+		return "xml"
+	case binding.MIMEYAML, binding.MIMEYAML2:
+		return "yaml"
+	case mimeCSV:
+		return "csv"
+	default:
+		return "json"
+	}
+}
+
+// csvTable is implemented by response types that can be rendered as CSV.
+type csvTable interface {
+	csvTable() (header []string, rows [][]string)
+}
+
+// albumList is a list of albums as a response body.
+type albumList []album
+
+// MarshalXML wraps the list in an <albums> root element.
+func (l albumList) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Name.Local = "albums"
+	return e.EncodeElement(struct {
+		Albums []album `xml:"album"`
+	}{l}, start)
+}
+
+func (l albumList) csvTable() ([]string, [][]string) {
+	rows := make([][]string, len(l))
+	for i, a := range l {
+		rows[i] = albumCSVRecord(a)
+	}
+	return albumCSVHeader, rows
+}
+
+func (a album) csvTable() ([]string, [][]string) {
+	return albumCSVHeader, [][]string{albumCSVRecord(a)}
+}
+
+func (p albumPage) csvTable() ([]string, [][]string) {
+	return albumList(p.Albums).csvTable()
+}
+
+// csvTable puts the error in the first row and each of its details in a
+// row of its own.
+func (e errorResponse) csvTable() ([]string, [][]string) {
+	rows := [][]string{{e.Error, "", e.Message}}
+	for _, d := range e.Details {
+		rows = append(rows, []string{d.Code, d.Field, d.Message})
+	}
+	return []string{"error", "field", "message"}, rows
+}
+
+func (r searchResults) csvTable() ([]string, [][]string) {
+	header := append(slices.Clone(albumCSVHeader), "score")
+	rows := make([][]string, len(r.Albums))
+	for i, a := range r.Albums {
+		rows[i] = append(albumCSVRecord(a.album), strconv.FormatFloat(a.Score, 'f', -1, 64))
+	}
+	return header, rows
+}
+
+func (r importReport) csvTable() ([]string, [][]string) {
+	rows := make([][]string, len(r.Results))
+	for i, res := range r.Results {
+		rows[i] = []string{strconv.Itoa(res.Line), res.ID, res.Status, res.Message}
+	}
+	return []string{"line", "id", "status", "message"}, rows
+}
+
+// negotiate picks the response type for the rest of the chain from the
+// Accept header, answering 406 when none of offeredFormats is acceptable.
+func negotiate(c *gin.Context) {
+	c.Header("Vary", "Accept")
+	format := c.NegotiateFormat(offeredFormats...)
+	if format == "" {
+		render(c, http.StatusNotAcceptable, errorResponse{
+			Error:   "not_acceptable",
+			Message: "Accept must allow one of " + strings.Join(offeredFormats, ", "),
+		})
+		c.Abort()
+		return
+	}
+	c.Set(formatKey, format)
+	c.Next()
+}
+
+// render writes obj with the response type chosen by negotiate, or as
+// JSON on routes that do not negotiate.
+func render(c *gin.Context, status int, obj any) {
+	if e, ok := obj.(errorResponse); ok {
+		e.RequestID = c.GetString(requestIDKey)
+		obj = e
+	}
+	switch c.GetString(formatKey) {
+	case binding.MIMEXML, binding.MIMEXML2:
+		c.XML(status, obj)
+	case binding.MIMEYAML, binding.MIMEYAML2:
+		c.YAML(status, obj)
+	case mimeCSV:
+		t, ok := obj.(csvTable)
+		if !ok {
+			c.IndentedJSON(status, obj)
+			return
+		}
+		if p, ok := obj.(albumPage); ok && p.NextCursor != "" {
+			// CSV has no envelope, so the cursor travels in a header.
+			c.Header("Next-Cursor", p.NextCursor)
+		}
+		header, rows := t.csvTable()
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Status(status)
+		w := csv.NewWriter(c.Writer)
+		w.Write(header)
+		w.WriteAll(rows)
+	default:
+		c.IndentedJSON(status, obj)
+	}
+}
+
+// bindAlbum decodes an album from the request body in the format named by
+// its Content-Type: XML, YAML or CSV, or JSON for any other type, as
+// clients such as curl send JSON with a form Content-Type by default. On
+// failure it writes a 400 response and returns false.
+func bindAlbum(c *gin.Context, a *album) bool {
+	var (
+		format string
+		err    error
+	)
+	switch c.ContentType() {
+	case binding.MIMEXML, binding.MIMEXML2:
+		format, err = "xml", c.ShouldBindWith(a, binding.XML)
+	case binding.MIMEYAML, binding.MIMEYAML2:
+		format, err = "yaml", c.ShouldBindWith(a, binding.YAML)
+	case mimeCSV:
+		format, err = "csv", bindAlbumCSV(c, a)
+	default:
+		format, err = "json", c.ShouldBindWith(a, binding.JSON)
+	}
+	if err != nil {
+		render(c, http.StatusBadRequest, errorResponse{
+			Error:   "invalid_" + format,
+			Message: fmt.Sprintf("failed to parse request body: %v", err),
+		})
+		return false
+	}
+	return true
+}
+
+// bindAlbumCSV decodes a CSV body holding a header row naming album
+// fields and a single album row.
+func bindAlbumCSV(c *gin.Context, a *album) error {
+	records, err := csv.NewReader(c.Request.Body).ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(records) != 2 {
+		return errors.New("expected a header row and exactly one album row")
+	}
+	for i, col := range records[0] {
+		v := records[1][i]
+		switch strings.TrimSpace(col) {
+		case "id":
+			a.ID = v
+		case "title":
+			a.Title = v
+		case "artist":
+			a.Artist = v
+		case "price":
+			if err := a.Price.UnmarshalText([]byte(v)); err != nil {
+				return err
+			}
+		case "currency":
+			a.Currency = v
+		case "version":
+			// Assigned by the store.
+		default:
+			return fmt.Errorf("unknown column %q", col)
+		}
+	}
+	return nil
+}
+
+// noRoute answers a request for a path no route matches.
+func noRoute(c *gin.Context) {
+	render(c, http.StatusNotFound, errorResponse{
+		Error:   "not_found",
+		Message: fmt.Sprintf("no such endpoint %s", c.Request.URL.Path),
+	})
+}
+
+// noMethod answers a request for a path whose routes do not accept the
+// request's method.
+func noMethod(c *gin.Context) {
+	render(c, http.StatusMethodNotAllowed, errorResponse{
+		Error:   "method_not_allowed",
+		Message: fmt.Sprintf("%s is not allowed on %s", c.Request.Method, c.Request.URL.Path),
+	})
+}
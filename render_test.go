@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	h := newTestApp(t, testConfig(), nil).router
+
+	tests := []struct {
+		accept      string
+		contentType string
+	}{
+		{"", "application/json"},
+		{"application/json", "application/json"},
+		{"application/xml", "application/xml"},
+		{"application/yaml", "application/yaml"},
+		{"text/csv", "text/csv"},
+		{"image/png, text/csv;q=0.5", "text/csv"},
+	}
+	for _, tt := range tests {
+		w := send(h, http.MethodGet, "/albums/1", "", "Accept", tt.accept)
+		wantStatus(t, w, http.StatusOK)
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, tt.contentType) {
+			t.Errorf("Accept %q: got Content-Type %s, want %s", tt.accept, ct, tt.contentType)
+		}
+	}
+
+	for _, accept := range []string{"image/png", "text/html"} {
+		w := send(h, http.MethodGet, "/albums/1", "", "Accept", accept)
+		wantError(t, w, http.StatusNotAcceptable, "not_acceptable")
+	}
+}
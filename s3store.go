@@ -0,0 +1,716 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"maps"
+	mrand "math/rand/v2"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	// s3IndexKey is the key of the index object under the store's prefix.
+	s3IndexKey = "index.json"
+
+	// s3Timeout bounds the S3 requests made by one store call.
+	s3Timeout = 10 * time.Second
+
+	// s3CommitAttempts is how many times a write is retried when another
+	// instance commits a change to the index first. Retries wait a random
+	// time of up to s3CommitBackoff, doubling each time to at most
+	// s3CommitMaxBackoff.
+	s3CommitAttempts   = 10
+	s3CommitBackoff    = 20 * time.Millisecond
+	s3CommitMaxBackoff = time.Second
+
+	// s3FetchWorkers is how many album objects are fetched at once.
+	s3FetchWorkers = 16
+
+	// s3MaxTombstones is how many purges the index remembers, so that
+	// instances reading it rarely can still report them in order.
+	s3MaxTombstones = 1000
+
+	// s3RefreshAttempts is how many times an index is read when album
+	// objects it lists are gone, starting s3RefreshBackoff apart and
+	// doubling the wait each time.
+	s3RefreshAttempts = 5
+	s3RefreshBackoff  = 50 * time.Millisecond
+
+	// s3PollInterval is how often a watched store checks the index for
+	// commits by other instances.
+	s3PollInterval = 2 * time.Second
+)
+
+var (
+	errS3Contention = errors.New("the album index kept changing; try again")
+	errSharedStore  = errors.New("the s3 storage backend is shared by every instance and cannot follow a leader")
+)
+
+// s3Index is the index object. It lists every album, in Seq order, with
+// the key of the object holding its current version, and the most recent
+// purges. Every commit increments Rev, which numbers the change it made
+// for every instance watching the store.
+type s3Index struct {
+	Rev    uint64         `json:"rev"`
+	Seq    uint64         `json:"seq"` // highest Seq assigned so far
+	Albums []s3IndexEntry `json:"albums"`
+	Purged []s3Tombstone  `json:"purged,omitempty"` // oldest first
+}
+
+type s3IndexEntry struct {
+	ID  string `json:"id"`
+	Seq uint64 `json:"seq"`
+	Key string `json:"key"`
+	Rev uint64 `json:"rev"` // of the commit that stored this version
+}
+
+// s3Tombstone records the commit that purged an album.
+type s3Tombstone struct {
+	ID  string `json:"id"`
+	Rev uint64 `json:"rev"`
+}
+
+// s3State is a consistent view of the catalog as of one index.
+type s3State struct {
+	etag  string // of the index object; empty if there is none yet
+	index s3Index
+	byID  map[string]album
+}
+
+// s3Store is an AlbumStore kept in an S3-compatible bucket, so that any
+// number of instances can share one catalog. Every album version is
+// written once, to an object of its own, and committed by replacing the
+// index object with a conditional write. A write that loses the race to
+// another instance reloads the index and tries again, so no update is
+// lost and version checks always see the latest album.
+//
+// Album objects never change, so each instance caches them and only
+// revalidates the index on reads. Every index it installs, whether read
+// or written, is compared with the one before to report changes to
+// watchers. The objects written for a commit that loses the race are
+// deleted where possible.
+type s3Store struct {
+	client *s3.Client
+	bucket string
+	prefix string
+	stop   chan struct{}
+	done   sync.WaitGroup
+
+	mu        sync.Mutex // guards the fields below
+	state     s3State
+	byKey     map[string]album // cached album objects
+	observers []func(after uint64, changes []change)
+}
+
+// newS3Client returns a client for the S3 API at endpoint, or for AWS
+// itself when endpoint is empty. Credentials and region come from the
+// usual AWS environment and config files.
+func newS3Client(ctx context.Context, endpoint string) (*s3.Client, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint == "" {
+			return
+		}
+		o.BaseEndpoint = aws.String(endpoint)
+		o.UsePathStyle = true
+		// Many S3-compatible servers do not support the default checksums.
+		o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+		o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+	}), nil
+}
+
+// openS3Store opens the catalog stored under prefix in bucket, seeding it
+// with seed if the bucket holds no index yet.
+func openS3Store(client *s3.Client, bucket, prefix string, seed []album) (*s3Store, error) {
+	s := &s3Store{
+		client: client,
+		bucket: bucket,
+		prefix: prefix,
+		stop:   make(chan struct{}),
+		byKey:  make(map[string]album),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+	st, err := s.refresh(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if st.etag == "" {
+		for _, a := range seed {
+			// Another instance may be seeding at the same time.
+			if _, err := s.Create(a); err != nil && !errors.Is(err, errDuplicateID) {
+				return nil, err
+			}
+		}
+	}
+	return s, nil
+}
+
+// s3Status returns the HTTP status of a failed S3 request, or 0.
+func s3Status(err error) int {
+	var re *awshttp.ResponseError
+	if errors.As(err, &re) {
+		return re.HTTPStatusCode()
+	}
+	return 0
+}
+
+// refresh returns the current catalog, reading the index again only if
+// it changed and fetching only album objects not already cached.
+func (s *s3Store) refresh(ctx context.Context) (s3State, error) {
+	wait := s3RefreshBackoff
+	for attempt := 1; ; attempt++ {
+		st, err := s.readIndex(ctx)
+		// An album object that is gone was usually replaced by a newer
+		// commit after the index was read; read the new index. One that
+		// stays gone was lost, and the index cannot be read.
+		if s3Status(err) != http.StatusNotFound || attempt == s3RefreshAttempts {
+			return st, err
+		}
+		select {
+		case <-ctx.Done():
+			return s3State{}, ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+func (s *s3Store) readIndex(ctx context.Context) (s3State, error) {
+	s.mu.Lock()
+	cached := s.state
+	s.mu.Unlock()
+
+	in := &s3.GetObjectInput{Bucket: &s.bucket, Key: aws.String(s.prefix + s3IndexKey)}
+	if cached.etag != "" {
+		in.IfNoneMatch = aws.String(cached.etag)
+	}
+	out, err := s.client.GetObject(ctx, in)
+	switch s3Status(err) {
+	case http.StatusNotModified:
+		return cached, nil
+	case http.StatusNotFound:
+		return s3State{byID: map[string]album{}}, nil
+	}
+	if err != nil {
+		return s3State{}, err
+	}
+	defer out.Body.Close()
+	var index s3Index
+	if err := json.NewDecoder(out.Body).Decode(&index); err != nil {
+		return s3State{}, err
+	}
+
+	s.mu.Lock()
+	var missing []string
+	for _, e := range index.Albums {
+		if _, ok := s.byKey[e.Key]; !ok {
+			missing = append(missing, e.Key)
+		}
+	}
+	s.mu.Unlock()
+	fetched, err := s.fetch(ctx, missing)
+	if err != nil {
+		return s3State{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, a := range fetched {
+		s.byKey[k] = a
+	}
+	if s.state.etag != "" && index.Rev <= s.state.index.Rev {
+		return s.state, nil
+	}
+	return s.install(aws.ToString(out.ETag), index), nil
+}
+
+// install makes index, whose album objects must all be cached, the
+// current state and returns it, reporting the changes committed since the
+// state it replaces to any observers. The caller must hold s.mu.
+func (s *s3Store) install(etag string, index s3Index) s3State {
+	prev := s.state
+	st := s3State{etag: etag, index: index, byID: make(map[string]album, len(index.Albums))}
+	keep := make(map[string]album, len(index.Albums))
+	for _, e := range index.Albums {
+		a := s.byKey[e.Key]
+		st.byID[e.ID] = a
+		keep[e.Key] = a
+	}
+	s.byKey = keep
+	s.state = st
+	if len(s.observers) > 0 {
+		if changes := s3Changes(prev, st); len(changes) > 0 {
+			for _, observe := range s.observers {
+				observe(prev.index.Rev, changes)
+			}
+		}
+	}
+	return st
+}
+
+// s3Changes returns the changes committed after prev up to st, numbered
+// by the commits that made them. Of several commits to one album, only
+// the last is seen.
+func s3Changes(prev, st s3State) []change {
+	var changes []change
+	for _, e := range st.index.Albums {
+		if e.Rev > prev.index.Rev {
+			a := st.byID[e.ID]
+			c := putChange(a)
+			c.Index = e.Rev
+			changes = append(changes, c)
+		}
+	}
+	purged := make(map[string]bool)
+	for _, t := range st.index.Purged {
+		if _, ok := st.byID[t.ID]; t.Rev > prev.index.Rev && !ok {
+			changes = append(changes, change{Index: t.Rev, Op: "purge", ID: t.ID})
+			purged[t.ID] = true
+		}
+	}
+	slices.SortFunc(changes, func(a, b change) int { return cmp.Compare(a.Index, b.Index) })
+	for id := range prev.byID {
+		if _, ok := st.byID[id]; !ok && !purged[id] {
+			// Its tombstone was already dropped.
+			changes = append(changes, change{Index: st.index.Rev, Op: "purge", ID: id})
+		}
+	}
+	return changes
+}
+
+// fetch reads the given album objects, a few at a time.
+func (s *s3Store) fetch(ctx context.Context, keys []string) (map[string]album, error) {
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		out      = make(map[string]album, len(keys))
+		next     = make(chan string)
+	)
+	for range min(s3FetchWorkers, len(keys)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range next {
+				a, err := s.getAlbum(ctx, key)
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				out[key] = a
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, k := range keys {
+		next <- k
+	}
+	close(next)
+	wg.Wait()
+	return out, firstErr
+}
+
+func (s *s3Store) getAlbum(ctx context.Context, key string) (album, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{Bucket: &s.bucket, Key: &key})
+	if err != nil {
+		return album{}, err
+	}
+	defer out.Body.Close()
+	var sa snapshotAlbum
+	if err := json.NewDecoder(out.Body).Decode(&sa); err != nil {
+		return album{}, err
+	}
+	sa.album.Seq = sa.Seq
+	return sa.album, nil
+}
+
+func (s *s3Store) putAlbum(ctx context.Context, key string, a album) error {
+	b, err := json.Marshal(snapshotAlbum{album: a, Seq: a.Seq})
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      &s.bucket,
+		Key:         &key,
+		Body:        bytes.NewReader(b),
+		ContentType: aws.String("application/json"),
+		IfNoneMatch: aws.String("*"),
+	})
+	return err
+}
+
+// putAlbums writes the given album objects, a few at a time.
+func (s *s3Store) putAlbums(ctx context.Context, albums map[string]album) error {
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		next     = make(chan string)
+	)
+	for range min(s3FetchWorkers, len(albums)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range next {
+				if err := s.putAlbum(ctx, key, albums[key]); err != nil {
+					mu.Lock()
+					firstErr = cmp.Or(firstErr, err)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for k := range albums {
+		next <- k
+	}
+	close(next)
+	wg.Wait()
+	return firstErr
+}
+
+// putIndex replaces the index object if its ETag is still etag, or
+// creates it if etag is empty, returning the new ETag.
+func (s *s3Store) putIndex(ctx context.Context, index s3Index, etag string) (string, error) {
+	b, err := json.Marshal(index)
+	if err != nil {
+		return "", err
+	}
+	in := &s3.PutObjectInput{
+		Bucket:      &s.bucket,
+		Key:         aws.String(s.prefix + s3IndexKey),
+		Body:        bytes.NewReader(b),
+		ContentType: aws.String("application/json"),
+	}
+	if etag == "" {
+		in.IfNoneMatch = aws.String("*")
+	} else {
+		in.IfMatch = aws.String(etag)
+	}
+	// A retry after a lost response would fail its precondition against
+	// the index it wrote itself, and be taken for another commit.
+	out, err := s.client.PutObject(ctx, in, func(o *s3.Options) { o.Retryer = aws.NopRetryer{} })
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.ETag), nil
+}
+
+// deleteObjects removes objects that are no longer referenced, leaving
+// behind any that fail to be.
+func (s *s3Store) deleteObjects(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		s.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &s.bucket, Key: &key})
+	}
+}
+
+// s3Edit is one album's part of a commit. change is given the current
+// album, with ok reporting whether there is one, and returns the album to
+// store in its place, or keep false to remove it.
+type s3Edit struct {
+	id     string
+	change func(cur album, ok bool) (a album, keep bool, err error)
+}
+
+// commit changes one album; see commitAll.
+func (s *s3Store) commit(id string, change func(cur album, ok bool) (a album, keep bool, err error)) (album, error) {
+	out, err := s.commitAll([]s3Edit{{id, change}})
+	if err != nil {
+		return album{}, err
+	}
+	return out[0], nil
+}
+
+// commitAll makes edits, each to a different album, in a single index
+// commit, so that other readers see all of them or none, and returns the
+// albums stored. The edits are retried against the latest index whenever
+// another instance commits first.
+func (s *s3Store) commitAll(edits []s3Edit) ([]album, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+
+	edited := make(map[string]bool, len(edits))
+	for _, e := range edits {
+		edited[e.id] = true
+	}
+	wait := s3CommitBackoff
+	for attempt := 1; attempt <= s3CommitAttempts; attempt++ {
+		if attempt > 1 {
+			// Instances that lost the same race would collide again if
+			// they all retried at once.
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(mrand.N(wait)):
+			}
+			wait = min(2*wait, s3CommitMaxBackoff)
+		}
+
+		st, err := s.refresh(ctx)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]album, len(edits))
+		keep := make([]bool, len(edits))
+		for i, e := range edits {
+			cur, ok := st.byID[e.id]
+			if out[i], keep[i], err = e.change(cur, ok); err != nil {
+				return nil, err
+			}
+			if ok && keep[i] {
+				out[i].Seq = cur.Seq
+			}
+		}
+
+		next := s3Index{Rev: st.index.Rev + 1, Seq: st.index.Seq, Purged: st.index.Purged}
+		var oldKeys []string
+		for _, e := range st.index.Albums {
+			if edited[e.ID] {
+				oldKeys = append(oldKeys, e.Key)
+				continue
+			}
+			next.Albums = append(next.Albums, e)
+		}
+		written := make(map[string]album)
+		for i, e := range edits {
+			if !keep[i] {
+				next.Purged = append(next.Purged, s3Tombstone{ID: e.id, Rev: next.Rev})
+				continue
+			}
+			a := &out[i]
+			if a.Seq == 0 {
+				next.Seq++
+				a.Seq = next.Seq
+			}
+			next.Seq = max(next.Seq, a.Seq)
+			key := s.prefix + e.id + "/" + newAlbumID() + ".json"
+			next.Albums = insertIndexEntry(next.Albums, s3IndexEntry{ID: e.id, Seq: a.Seq, Key: key, Rev: next.Rev})
+			written[key] = *a
+		}
+		next.Purged = next.Purged[max(0, len(next.Purged)-s3MaxTombstones):]
+		if err := s.putAlbums(ctx, written); err != nil {
+			s.deleteObjects(ctx, slices.Collect(maps.Keys(written)))
+			return nil, err
+		}
+
+		etag, err := s.putIndex(ctx, next, st.etag)
+		if s3Status(err) == http.StatusPreconditionFailed {
+			s.deleteObjects(ctx, slices.Collect(maps.Keys(written)))
+			continue
+		}
+		if err != nil {
+			// The index may have been written all the same, so the new
+			// objects must stay.
+			return nil, err
+		}
+
+		s.mu.Lock()
+		maps.Copy(s.byKey, written)
+		if next.Rev > s.state.index.Rev {
+			s.install(etag, next)
+		}
+		s.mu.Unlock()
+		s.deleteObjects(ctx, oldKeys)
+		return out, nil
+	}
+	return nil, errS3Contention
+}
+
+// insertIndexEntry adds e to entries, keeping them in Seq order.
+func insertIndexEntry(entries []s3IndexEntry, e s3IndexEntry) []s3IndexEntry {
+	i := len(entries)
+	for i > 0 && entries[i-1].Seq > e.Seq {
+		i--
+	}
+	entries = append(entries, s3IndexEntry{})
+	copy(entries[i+1:], entries[i:])
+	entries[i] = e
+	return entries
+}
+
+func (s *s3Store) current() (s3State, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+	return s.refresh(ctx)
+}
+
+func (s *s3Store) Get(id string) (album, error) {
+	st, err := s.current()
+	if err != nil {
+		return album{}, err
+	}
+	a, ok := st.byID[id]
+	if !ok || a.DeletedAt != nil {
+		return album{}, errAlbumNotFound
+	}
+	return a, nil
+}
+
+func (s *s3Store) List() ([]album, error) {
+	return s.list(false)
+}
+
+func (s *s3Store) ListAfter(after uint64, limit int) ([]album, error) {
+	st, err := s.current()
+	if err != nil {
+		return nil, err
+	}
+	entries := st.index.Albums
+	i, _ := slices.BinarySearchFunc(entries, after+1, func(e s3IndexEntry, seq uint64) int {
+		return cmp.Compare(e.Seq, seq)
+	})
+	out := make([]album, 0, min(limit, len(entries)-i))
+	for _, e := range entries[i:] {
+		if len(out) == limit {
+			break
+		}
+		if a := st.byID[e.ID]; a.DeletedAt == nil {
+			out = append(out, a)
+		}
+	}
+	return out, nil
+}
+
+func (s *s3Store) Trash() ([]album, error) {
+	return s.list(true)
+}
+
+// list returns either the live or the soft-deleted albums in order.
+func (s *s3Store) list(deleted bool) ([]album, error) {
+	st, err := s.current()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]album, 0, len(st.index.Albums))
+	for _, e := range st.index.Albums {
+		if a := st.byID[e.ID]; (a.DeletedAt != nil) == deleted {
+			out = append(out, a)
+		}
+	}
+	return out, nil
+}
+
+func (s *s3Store) Create(a album) (album, error) {
+	return s.commit(a.ID, func(cur album, ok bool) (album, bool, error) {
+		a, err := createTransition(cur, ok, a)
+		return a, true, err
+	})
+}
+
+func (s *s3Store) CreateBatch(albums []album) ([]album, error) {
+	edits := make([]s3Edit, len(albums))
+	for i, a := range albums {
+		edits[i] = s3Edit{a.ID, func(cur album, ok bool) (album, bool, error) {
+			a, err := createTransition(cur, ok, a)
+			if err != nil {
+				return a, true, &batchError{index: i, err: err}
+			}
+			return a, true, nil
+		}}
+	}
+	// A batch naming an ID twice would otherwise store only one album.
+	if _, err := createBatchTransition(albums, func(string) (album, bool) { return album{}, false }); err != nil {
+		return nil, err
+	}
+	return s.commitAll(edits)
+}
+
+func (s *s3Store) Update(a album, ifRev revision) (album, error) {
+	return s.commit(a.ID, func(cur album, ok bool) (album, bool, error) {
+		a, err := updateTransition(cur, ok, a, ifRev)
+		return a, true, err
+	})
+}
+
+func (s *s3Store) Delete(id string, ifRev revision) (album, error) {
+	return s.commit(id, func(cur album, ok bool) (album, bool, error) {
+		a, err := deleteTransition(cur, ok, ifRev)
+		return a, true, err
+	})
+}
+
+func (s *s3Store) Restore(id string) (album, error) {
+	return s.commit(id, func(cur album, ok bool) (album, bool, error) {
+		a, err := restoreTransition(cur, ok)
+		return a, true, err
+	})
+}
+
+func (s *s3Store) Purge(id string, ifRev revision) error {
+	_, err := s.commit(id, func(cur album, ok bool) (album, bool, error) {
+		return album{}, false, purgeCheck(cur, ok, ifRev)
+	})
+	return err
+}
+
+// watch has observe called with every commit to the store, checking for
+// commits by other instances every s3PollInterval.
+func (s *s3Store) watch(observe func(after uint64, changes []change)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	observe(s.state.index.Rev, nil)
+	s.observers = append(s.observers, observe)
+	if len(s.observers) > 1 {
+		return true
+	}
+
+	s.done.Add(1)
+	go func() {
+		defer s.done.Done()
+		t := time.NewTicker(s3PollInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-t.C:
+				if _, err := s.current(); err != nil {
+					log.Printf("s3 store: read index: %v", err)
+				}
+			}
+		}
+	}()
+	return true
+}
+
+// Close stops watching the index.
+func (s *s3Store) Close() error {
+	close(s.stop)
+	s.done.Wait()
+	return nil
+}
+
+// The bucket is already shared, so there is nothing to replicate.
+
+func (s *s3Store) apply(album) error          { return errSharedStore }
+func (s *s3Store) discard(string) error       { return errSharedStore }
+func (s *s3Store) reset(albums []album) error { return errSharedStore }
+
+// s3Prefix normalizes a key prefix to end in exactly one slash, or to be
+// empty.
+func s3Prefix(p string) string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return ""
+	}
+	return p + "/"
+}
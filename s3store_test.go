@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is an in-memory S3-compatible server with path-style addressing
+// that supports the conditional reads and writes the s3 store relies on.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]fakeObject // by path
+	writes  int
+
+	// indexFault, if set, answers the next index write with this status
+	// after storing it, as when a response is lost.
+	indexFault int
+}
+
+type fakeObject struct {
+	body []byte
+	etag string
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: make(map[string]fakeObject)}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := r.URL.Path
+	obj, ok := f.objects[key]
+	fail := func(status int, code string) {
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "<Error><Code>%s</Code></Error>", code)
+	}
+	switch r.Method {
+	case http.MethodGet:
+		switch {
+		case !ok:
+			fail(http.StatusNotFound, "NoSuchKey")
+		case r.Header.Get("If-None-Match") == obj.etag:
+			w.Header().Set("ETag", obj.etag)
+			w.WriteHeader(http.StatusNotModified)
+		default:
+			w.Header().Set("ETag", obj.etag)
+			w.Write(obj.body)
+		}
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			fail(http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+		if (ifMatch != "" && (!ok || obj.etag != ifMatch)) || (ifNoneMatch == "*" && ok) {
+			fail(http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		f.writes++
+		sum := md5.Sum(fmt.Appendf(body, "%d", f.writes))
+		etag := `"` + hex.EncodeToString(sum[:]) + `"`
+		f.objects[key] = fakeObject{body: body, etag: etag}
+		if strings.HasSuffix(key, "/"+s3IndexKey) && f.indexFault != 0 {
+			fail(f.indexFault, "InternalError")
+			f.indexFault = 0
+			return
+		}
+		w.Header().Set("ETag", etag)
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		fail(http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// keys returns the number of objects stored under prefix.
+func (f *fakeS3) keys(prefix string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for k := range f.objects {
+		if strings.HasPrefix(k, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+// s3TestBucket returns a function opening stores that share a fresh
+// catalog, and the fake server holding it. The stores use the endpoint in
+// ALBUM_TEST_S3_ENDPOINT and the bucket in ALBUM_TEST_S3_BUCKET if set,
+// with credentials from the environment, and the fake is nil; otherwise
+// they use an in-process fake.
+func s3TestBucket(t *testing.T) (open func() *s3Store, fake *fakeS3) {
+	endpoint, bucket := os.Getenv("ALBUM_TEST_S3_ENDPOINT"), os.Getenv("ALBUM_TEST_S3_BUCKET")
+	if endpoint == "" {
+		fake = newFakeS3()
+		srv := httptest.NewServer(fake)
+		t.Cleanup(srv.Close)
+		endpoint, bucket = srv.URL, "albums"
+		t.Setenv("AWS_ACCESS_KEY_ID", "test")
+		t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+		t.Setenv("AWS_REGION", "us-east-1")
+		t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	}
+	client, err := newS3Client(context.Background(), endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := "test-" + newAlbumID() + "/"
+	return func() *s3Store {
+		s, err := openS3Store(client, bucket, prefix, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	}, fake
+}
+
+func TestS3StoreShared(t *testing.T) {
+	open, _ := s3TestBucket(t)
+	a, b := open(), open()
+
+	if _, err := a.Create(album{ID: "1", Title: "Blue Train"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.Get("1")
+	if err != nil || got.Title != "Blue Train" {
+		t.Fatalf("Get from the other store = %+v, %v", got, err)
+	}
+	if _, err := b.Update(album{ID: "1", Title: "Jeru"}, got.revision()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Update(album{ID: "1", Title: "Giant Steps"}, got.revision()); !errors.Is(err, errVersionMismatch) {
+		t.Fatalf("stale update: got error %v, want %v", err, errVersionMismatch)
+	}
+
+	if _, err := a.CreateBatch([]album{{ID: "2"}, {ID: "1"}}); !errors.Is(err, errDuplicateID) {
+		t.Fatalf("batch with an existing ID: got error %v, want %v", err, errDuplicateID)
+	}
+	if _, err := a.CreateBatch([]album{{ID: "2"}, {ID: "3"}, {ID: "2"}}); !errors.Is(err, errDuplicateID) {
+		t.Fatalf("batch repeating an ID: got error %v, want %v", err, errDuplicateID)
+	}
+	if _, err := a.CreateBatch([]album{{ID: "2"}, {ID: "3"}}); err != nil {
+		t.Fatal(err)
+	}
+	list, err := b.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, a := range list {
+		ids = append(ids, a.ID)
+	}
+	if strings.Join(ids, ",") != "1,2,3" {
+		t.Errorf("listed %v, want 1,2,3", ids)
+	}
+
+	if err := b.Purge("2", anyRevision); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Get("2"); !errors.Is(err, errAlbumNotFound) {
+		t.Errorf("Get of a purged album: got error %v, want %v", err, errAlbumNotFound)
+	}
+}
+
+func TestS3StoreContention(t *testing.T) {
+	open, fake := s3TestBucket(t)
+	stores := []*s3Store{open(), open()}
+
+	const writers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := stores[i%2].Create(album{ID: fmt.Sprint(i)})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	list, err := stores[0].List()
+	if err != nil || len(list) != writers {
+		t.Fatalf("got %d albums, %v; want %d", len(list), err, writers)
+	}
+	// The objects of the writes that lost a race are gone.
+	if fake != nil {
+		if n := fake.keys("/albums/" + stores[0].prefix); n != writers+1 {
+			t.Errorf("got %d objects, want %d albums and the index", n, writers)
+		}
+	}
+}
+
+func TestS3StoreIndexWriteFails(t *testing.T) {
+	open, fake := s3TestBucket(t)
+	if fake == nil {
+		t.Skip("needs the fake S3 server")
+	}
+	s := open()
+
+	// The index is written but the store is told it failed, so it cannot
+	// know whether the album was committed and must keep its object.
+	fake.mu.Lock()
+	fake.indexFault = http.StatusInternalServerError
+	fake.mu.Unlock()
+	if _, err := s.Create(album{ID: "1"}); s3Status(err) != http.StatusInternalServerError {
+		t.Fatalf("got error %v, want a 500", err)
+	}
+	if _, err := open().Get("1"); err != nil {
+		t.Errorf("Get after an ambiguous commit: %v", err)
+	}
+}
+
+func TestS3StoreMissingObject(t *testing.T) {
+	open, fake := s3TestBucket(t)
+	if fake == nil {
+		t.Skip("needs the fake S3 server")
+	}
+	first := open()
+	if _, err := first.Create(album{ID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	fake.mu.Lock()
+	for k := range fake.objects {
+		if !strings.HasSuffix(k, "/"+s3IndexKey) {
+			delete(fake.objects, k)
+		}
+	}
+	fake.mu.Unlock()
+
+	// A new store has nothing cached, and must give up on the object
+	// rather than read the index until its deadline.
+	start := time.Now()
+	if _, err := openS3Store(first.client, first.bucket, first.prefix, nil); s3Status(err) != http.StatusNotFound {
+		t.Fatalf("got error %v, want a 404", err)
+	}
+	if d := time.Since(start); d > s3Timeout/2 {
+		t.Errorf("took %v to give up", d)
+	}
+}
+
+func TestS3StoreListAfter(t *testing.T) {
+	open, _ := s3TestBucket(t)
+	testListAfter(t, open())
+}
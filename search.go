@@ -0,0 +1,398 @@
+package main
+
+import (
+	"cmp"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// titleWeight and artistWeight scale term frequencies so that a match
+	// in the title ranks above the same match in the artist.
+	titleWeight  = 2.0
+	artistWeight = 1.0
+
+	// prefixWeight scales matches where the query token is only a prefix
+	// of the indexed term.
+	prefixWeight = 0.5
+)
+
+var nonLetters = regexp.MustCompile(`[^a-z]+`)
+
+// tokenize lowercases s and splits it into runs of letters, the same
+// way the mapper service counts words.
+func tokenize(s string) []string {
+	s = strings.ToLower(s)
+	s = nonLetters.ReplaceAllString(s, " ")
+	return strings.Fields(s)
+}
+
+// posting records how often a term occurs in one album.
+type posting struct {
+	title  int
+	artist int
+}
+
+// searchIndex is an inverted index over album titles and artists. It is
+// safe for concurrent use.
+type searchIndex struct {
+	mu       sync.RWMutex
+	postings map[string]map[string]posting // term -> album ID -> frequencies
+	terms    []string                      // sorted keys of postings, for prefix lookups
+	docs     map[string][]string           // album ID -> distinct terms indexed for it
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: make(map[string]map[string]posting),
+		docs:     make(map[string][]string),
+	}
+}
+
+// put indexes a, replacing any previous entry for its ID.
+func (x *searchIndex) put(a album) {
+	freq := make(map[string]posting)
+	for _, t := range tokenize(a.Title) {
+		p := freq[t]
+		p.title++
+		freq[t] = p
+	}
+	for _, t := range tokenize(a.Artist) {
+		p := freq[t]
+		p.artist++
+		freq[t] = p
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.removeLocked(a.ID)
+	terms := make([]string, 0, len(freq))
+	for t, p := range freq {
+		ids, ok := x.postings[t]
+		if !ok {
+			ids = make(map[string]posting)
+			x.postings[t] = ids
+			i, _ := slices.BinarySearch(x.terms, t)
+			x.terms = slices.Insert(x.terms, i, t)
+		}
+		ids[a.ID] = p
+		terms = append(terms, t)
+	}
+	x.docs[a.ID] = terms
+}
+
+// reset replaces the whole index with the given albums.
+func (x *searchIndex) reset(albums []album) {
+	x.mu.Lock()
+	x.postings = make(map[string]map[string]posting)
+	x.terms = nil
+	x.docs = make(map[string][]string)
+	x.mu.Unlock()
+	for _, a := range albums {
+		x.put(a)
+	}
+}
+
+// remove drops the album with the given ID from the index.
+func (x *searchIndex) remove(id string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.removeLocked(id)
+}
+
+func (x *searchIndex) removeLocked(id string) {
+	for _, t := range x.docs[id] {
+		ids := x.postings[t]
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(x.postings, t)
+			if i, ok := slices.BinarySearch(x.terms, t); ok {
+				x.terms = slices.Delete(x.terms, i, i+1)
+			}
+		}
+	}
+	delete(x.docs, id)
+}
+
+// searchHit is an album ID with its relevance score.
+type searchHit struct {
+	id    string
+	score float64
+}
+
+// search returns the albums matching every token in q, most relevant
+// first. A query token matches an indexed term equal to it or, at a lower
+// weight, any term it is a prefix of.
+func (x *searchIndex) search(q string) []searchHit {
+	tokens := tokenize(q)
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	var scores map[string]float64
+	for _, tok := range tokens {
+		tokScores := make(map[string]float64)
+		i, _ := slices.BinarySearch(x.terms, tok)
+		for ; i < len(x.terms) && strings.HasPrefix(x.terms[i], tok); i++ {
+			term := x.terms[i]
+			weight := 1.0
+			if term != tok {
+				weight = prefixWeight
+			}
+			for id, p := range x.postings[term] {
+				s := weight * (titleWeight*float64(p.title) + artistWeight*float64(p.artist))
+				// Count a token once per album, by its best-matching term.
+				tokScores[id] = max(tokScores[id], s)
+			}
+		}
+
+		if scores == nil {
+			scores = tokScores
+			continue
+		}
+		for id, s := range scores {
+			if ts, ok := tokScores[id]; ok {
+				scores[id] = s + ts
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	hits := make([]searchHit, 0, len(scores))
+	for id, s := range scores {
+		hits = append(hits, searchHit{id: id, score: s})
+	}
+	slices.SortFunc(hits, func(a, b searchHit) int {
+		if c := cmp.Compare(b.score, a.score); c != 0 {
+			return c
+		}
+		return strings.Compare(a.id, b.id)
+	})
+	return hits
+}
+
+// indexedStore is an AlbumStore that keeps a searchIndex in step with
+// the live albums of the store it wraps.
+type indexedStore struct {
+	replicaStore
+	index  *searchIndex
+	shared bool // the store reports every change itself; see sharedStore
+
+	mu sync.Mutex // orders writes so the index sees them in store order
+}
+
+// newIndexedStore wraps store, indexing the albums it already holds.
+func newIndexedStore(store replicaStore, index *searchIndex) (*indexedStore, error) {
+	albums, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range albums {
+		index.put(a)
+	}
+	s := &indexedStore{replicaStore: store, index: index}
+	if shared, ok := store.(sharedStore); ok {
+		s.shared = shared.watch(s.observe)
+	}
+	return s, nil
+}
+
+// observe indexes changes reported by a shared store.
+func (s *indexedStore) observe(_ uint64, changes []change) {
+	for _, c := range changes {
+		if c.Op == "put" && c.Album.DeletedAt == nil {
+			s.index.put(c.Album.album)
+		} else {
+			s.index.remove(c.ID)
+		}
+	}
+}
+
+// watch passes observe on to a shared store once s has indexed the
+// changes it reports.
+func (s *indexedStore) watch(observe func(after uint64, changes []change)) bool {
+	shared, ok := s.replicaStore.(sharedStore)
+	return ok && shared.watch(observe)
+}
+
+// written indexes the outcome of a write made through s. A shared store
+// has already reported it, and indexing it again could undo a later
+// change made by another instance.
+func (s *indexedStore) written(a album) {
+	switch {
+	case s.shared:
+	case a.DeletedAt == nil:
+		s.index.put(a)
+	default:
+		s.index.remove(a.ID)
+	}
+}
+
+func (s *indexedStore) Create(a album) (album, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, err := s.replicaStore.Create(a)
+	if err != nil {
+		return a, err
+	}
+	s.written(a)
+	return a, nil
+}
+
+func (s *indexedStore) CreateBatch(albums []album) ([]album, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	albums, err := s.replicaStore.CreateBatch(albums)
+	if err != nil {
+		return albums, err
+	}
+	for _, a := range albums {
+		s.written(a)
+	}
+	return albums, nil
+}
+
+func (s *indexedStore) Update(a album, ifRev revision) (album, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, err := s.replicaStore.Update(a, ifRev)
+	if err != nil {
+		return a, err
+	}
+	s.written(a)
+	return a, nil
+}
+
+func (s *indexedStore) Delete(id string, ifRev revision) (album, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, err := s.replicaStore.Delete(id, ifRev)
+	if err != nil {
+		return a, err
+	}
+	s.written(a)
+	return a, nil
+}
+
+func (s *indexedStore) Restore(id string) (album, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, err := s.replicaStore.Restore(id)
+	if err != nil {
+		return a, err
+	}
+	s.written(a)
+	return a, nil
+}
+
+func (s *indexedStore) Purge(id string, ifRev revision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.replicaStore.Purge(id, ifRev); err != nil {
+		return err
+	}
+	if !s.shared {
+		s.index.remove(id)
+	}
+	return nil
+}
+
+func (s *indexedStore) apply(a album) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.replicaStore.apply(a); err != nil {
+		return err
+	}
+	if a.DeletedAt == nil {
+		s.index.put(a)
+	} else {
+		s.index.remove(a.ID)
+	}
+	return nil
+}
+
+func (s *indexedStore) discard(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.replicaStore.discard(id); err != nil {
+		return err
+	}
+	s.index.remove(id)
+	return nil
+}
+
+func (s *indexedStore) reset(albums []album) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.replicaStore.reset(albums); err != nil {
+		return err
+	}
+	live := make([]album, 0, len(albums))
+	for _, a := range albums {
+		if a.DeletedAt == nil {
+			live = append(live, a)
+		}
+	}
+	s.index.reset(live)
+	return nil
+}
+
+// scoredAlbum is an album in search results with its relevance score.
+type scoredAlbum struct {
+	album `yaml:",inline"`
+	Score float64 `json:"score" xml:"score"`
+}
+
+// searchResults is the response envelope for album searches.
+type searchResults struct {
+	XMLName xml.Name      `json:"-" xml:"search"`
+	Query   string        `json:"query" xml:"query,attr"`
+	Albums  []scoredAlbum `json:"albums" xml:"album"`
+}
+
+// searchAlbums returns the albums whose title or artist match the q
+// query parameter in the negotiated format, most relevant first.
+func (s *server) searchAlbums(c *gin.Context) {
+	q := c.Query("q")
+	if strings.TrimSpace(q) == "" {
+		render(c, http.StatusBadRequest, errorResponse{
+			Error:   "invalid_query",
+			Message: "q is required and cannot be empty",
+		})
+		return
+	}
+
+	limit, ok := parseLimit(c)
+	if !ok {
+		return
+	}
+
+	res := searchResults{Query: q, Albums: []scoredAlbum{}}
+	for _, h := range s.index.search(q) {
+		if len(res.Albums) == limit {
+			break
+		}
+		a, err := s.store.Get(h.id)
+		if errors.Is(err, errAlbumNotFound) {
+			// Deleted since the index was read.
+			continue
+		}
+		if err != nil {
+			respondStorageError(c, err)
+			return
+		}
+		res.Albums = append(res.Albums, scoredAlbum{album: a, Score: h.score})
+	}
+	render(c, http.StatusOK, res)
+}
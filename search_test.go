@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// failingGetStore fails to read the album with ID bad.
+type failingGetStore struct {
+	replicaStore
+	bad string
+}
+
+func (s failingGetStore) Get(id string) (album, error) {
+	if id == s.bad {
+		return album{}, errors.New("disk on fire")
+	}
+	return s.replicaStore.Get(id)
+}
+
+func TestSearchAlbums(t *testing.T) {
+	h := newTestApp(t, testConfig(), nil).router
+
+	w := send(h, http.MethodGet, "/albums/search?q=coltrane", "")
+	wantStatus(t, w, http.StatusOK)
+	if res := decode[searchResults](t, w); len(res.Albums) != 1 || res.Albums[0].ID != "1" {
+		t.Errorf("got %+v, want album 1", res.Albums)
+	}
+
+	// Albums deleted since they were indexed are left out.
+	wantStatus(t, send(h, http.MethodDelete, "/albums/1", "", "If-Match", "*"), http.StatusNoContent)
+	w = send(h, http.MethodGet, "/albums/search?q=coltrane", "")
+	wantStatus(t, w, http.StatusOK)
+	if res := decode[searchResults](t, w); len(res.Albums) != 0 {
+		t.Errorf("got %+v after deleting album 1", res.Albums)
+	}
+
+	wantError(t, send(h, http.MethodGet, "/albums/search?q=+", ""), http.StatusBadRequest, "invalid_query")
+}
+
+func TestSearchAlbumsStorageError(t *testing.T) {
+	store := failingGetStore{replicaStore: newMemoryStore(seedAlbums), bad: "1"}
+	h := newTestApp(t, testConfig(), store).router
+
+	w := send(h, http.MethodGet, "/albums/search?q=coltrane", "")
+	wantError(t, w, http.StatusInternalServerError, "storage_error")
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	errAlbumNotFound   = errors.New("album not found")
+	errDuplicateID     = errors.New("album ID already exists")
+	errVersionMismatch = errors.New("album version does not match")
+)
+
+// batchError reports the album that kept a CreateBatch from creating any,
+// by its position in the batch.
+type batchError struct {
+	index int
+	err   error
+}
+
+func (e *batchError) Error() string {
+	return fmt.Sprintf("album %d of the batch: %v", e.index+1, e.err)
+}
+
+func (e *batchError) Unwrap() error { return e.err }
+
+// revision identifies one state of one album: Seq tells apart albums
+// that reused an ID after a purge, and Version the writes to each. It is
+// what an album's ETag encodes.
+type revision struct {
+	Seq     uint64
+	Version int64
+}
+
+// anyRevision makes a conditional write unconditional.
+var anyRevision revision
+
+// revision returns the revision a is at.
+func (a album) revision() revision {
+	return revision{Seq: a.Seq, Version: a.Version}
+}
+
+// matches reports whether a write conditional on r may change a.
+func (r revision) matches(a album) bool {
+	return r == anyRevision || r == a.revision()
+}
+
+// AlbumStore is the storage the album handlers depend on.
+// Implementations must be safe for concurrent use.
+//
+// Every stored album carries a Version, starting at 1 and incremented by
+// each write. Writes taking an ifRev fail with errVersionMismatch unless
+// it is the album's current revision or anyRevision.
+//
+// Delete is a soft delete: the album moves to the trash, where Get, List
+// and Update no longer see it, until it is restored or purged. Its ID
+// stays reserved while it is in the trash.
+type AlbumStore interface {
+	Get(id string) (album, error)
+	List() ([]album, error)
+	// ListAfter lists up to limit live albums following the one whose Seq
+	// is after, in the order List uses; after 0 starts with the first.
+	ListAfter(after uint64, limit int) ([]album, error)
+	Create(a album) (album, error)
+	// CreateBatch creates every album or, failing with a *batchError if
+	// any one cannot be created, none. Readers see all of them at once.
+	CreateBatch(albums []album) ([]album, error)
+	Update(a album, ifRev revision) (album, error)
+	// Delete moves an album to the trash and returns it as trashed.
+	Delete(id string, ifRev revision) (album, error)
+
+	// Trash lists soft-deleted albums.
+	Trash() ([]album, error)
+	// Restore moves an album out of the trash and returns it.
+	Restore(id string) (album, error)
+	// Purge permanently removes an album, whether or not it is in the trash.
+	Purge(id string, ifRev revision) error
+}
+
+// replica is implemented by stores that can take the writes of the
+// cluster leader, which has already applied the write rules to them.
+// Albums are stored as given, keeping their Version and Seq.
+type replica interface {
+	// apply stores a, replacing any album with its ID.
+	apply(a album) error
+	// discard permanently removes an album, ignoring unknown IDs.
+	discard(id string) error
+	// reset replaces every album in the store.
+	reset(albums []album) error
+}
+
+// replicaStore is an AlbumStore that can also act as a replica.
+type replicaStore interface {
+	AlbumStore
+	replica
+}
+
+// sharedStore is implemented by stores that other instances write to as
+// well, so that their albums change without going through this one.
+type sharedStore interface {
+	// watch has observe called with every later change to the store,
+	// whichever instance made it, in order. Each change is numbered by
+	// the store, the same way on every instance, and each call passes
+	// the index the changes follow. The first call, made before watch
+	// returns, passes no changes. watch reports false, registering
+	// nothing, if the store is not shared after all. State derived from a
+	// shared store's albums is kept up to date this way rather than by
+	// tracking its own writes. observe must not call back into the store.
+	watch(observe func(after uint64, changes []change)) bool
+}
+
+// The transitions below hold the write rules shared by every AlbumStore.
+// Each takes the currently stored album (ok reports whether there is
+// one) and returns the album to store in its place.
+
+func createTransition(cur album, ok bool, a album) (album, error) {
+	if ok {
+		return album{}, errDuplicateID
+	}
+	a.DeletedAt = nil
+	a.Seq = 0
+	a.Version = 1
+	return a, nil
+}
+
+// createBatchTransition applies createTransition to each album of a
+// batch, in which an ID may only appear once. lookup returns the stored
+// album with an ID.
+func createBatchTransition(albums []album, lookup func(id string) (album, bool)) ([]album, error) {
+	out := make([]album, len(albums))
+	seen := make(map[string]bool, len(albums))
+	for i, a := range albums {
+		cur, ok := lookup(a.ID)
+		a, err := createTransition(cur, ok || seen[a.ID], a)
+		if err != nil {
+			return nil, &batchError{index: i, err: err}
+		}
+		seen[a.ID] = true
+		out[i] = a
+	}
+	return out, nil
+}
+
+func updateTransition(cur album, ok bool, a album, ifRev revision) (album, error) {
+	if !ok || cur.DeletedAt != nil {
+		return album{}, errAlbumNotFound
+	}
+	if !ifRev.matches(cur) {
+		return album{}, errVersionMismatch
+	}
+	a.DeletedAt = nil
+	a.Seq = cur.Seq
+	a.Version = cur.Version + 1
+	return a, nil
+}
+
+func deleteTransition(cur album, ok bool, ifRev revision) (album, error) {
+	if !ok || cur.DeletedAt != nil {
+		return album{}, errAlbumNotFound
+	}
+	if !ifRev.matches(cur) {
+		return album{}, errVersionMismatch
+	}
+	now := time.Now().UTC()
+	cur.DeletedAt = &now
+	cur.Version++
+	return cur, nil
+}
+
+func restoreTransition(cur album, ok bool) (album, error) {
+	if !ok || cur.DeletedAt == nil {
+		return album{}, errAlbumNotFound
+	}
+	cur.DeletedAt = nil
+	cur.Version++
+	return cur, nil
+}
+
+// purgeCheck reports whether cur may be purged; there is no album after.
+func purgeCheck(cur album, ok bool, ifRev revision) error {
+	if !ok {
+		return errAlbumNotFound
+	}
+	if !ifRev.matches(cur) {
+		return errVersionMismatch
+	}
+	return nil
+}
+
+// memoryStore is the default in-memory AlbumStore. Albums are indexed by
+// ID and listed in insertion order.
+type memoryStore struct {
+	mu    sync.RWMutex
+	byID  map[string]album
+	order []string // IDs in ascending Seq order
+	seq   uint64   // highest Seq assigned so far
+}
+
+// newMemoryStore returns a memoryStore seeded with the given albums.
+func newMemoryStore(seed []album) *memoryStore {
+	s := &memoryStore{byID: make(map[string]album, len(seed))}
+	for _, a := range seed {
+		if _, ok := s.byID[a.ID]; ok {
+			continue
+		}
+		a.Seq = 0
+		s.putLocked(a)
+	}
+	return s
+}
+
+func (s *memoryStore) Get(id string) (album, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	a, ok := s.byID[id]
+	if !ok || a.DeletedAt != nil {
+		return album{}, errAlbumNotFound
+	}
+	return a, nil
+}
+
+func (s *memoryStore) List() ([]album, error) {
+	return s.list(false), nil
+}
+
+func (s *memoryStore) ListAfter(after uint64, limit int) ([]album, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i, _ := slices.BinarySearchFunc(s.order, after+1, func(id string, seq uint64) int {
+		return cmp.Compare(s.byID[id].Seq, seq)
+	})
+	out := make([]album, 0, min(limit, len(s.order)-i))
+	for _, id := range s.order[i:] {
+		if len(out) == limit {
+			break
+		}
+		if a := s.byID[id]; a.DeletedAt == nil {
+			out = append(out, a)
+		}
+	}
+	return out, nil
+}
+
+func (s *memoryStore) Trash() ([]album, error) {
+	return s.list(true), nil
+}
+
+// all returns every album, live or soft-deleted, in order.
+func (s *memoryStore) all() []album {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]album, 0, len(s.order))
+	for _, id := range s.order {
+		out = append(out, s.byID[id])
+	}
+	return out
+}
+
+// list returns either the live or the soft-deleted albums in order.
+func (s *memoryStore) list(deleted bool) []album {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]album, 0, len(s.order))
+	for _, id := range s.order {
+		if a := s.byID[id]; (a.DeletedAt != nil) == deleted {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+func (s *memoryStore) Create(a album) (album, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.byID[a.ID]
+	a, err := createTransition(cur, ok, a)
+	if err != nil {
+		return album{}, err
+	}
+	return s.putLocked(a), nil
+}
+
+func (s *memoryStore) CreateBatch(albums []album) ([]album, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	albums, err := createBatchTransition(albums, func(id string) (album, bool) {
+		a, ok := s.byID[id]
+		return a, ok
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, a := range albums {
+		albums[i] = s.putLocked(a)
+	}
+	return albums, nil
+}
+
+func (s *memoryStore) Update(a album, ifRev revision) (album, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.byID[a.ID]
+	a, err := updateTransition(cur, ok, a, ifRev)
+	if err != nil {
+		return album{}, err
+	}
+	return s.putLocked(a), nil
+}
+
+func (s *memoryStore) Delete(id string, ifRev revision) (album, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.byID[id]
+	a, err := deleteTransition(cur, ok, ifRev)
+	if err != nil {
+		return album{}, err
+	}
+	return s.putLocked(a), nil
+}
+
+func (s *memoryStore) Restore(id string) (album, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.byID[id]
+	a, err := restoreTransition(cur, ok)
+	if err != nil {
+		return album{}, err
+	}
+	return s.putLocked(a), nil
+}
+
+func (s *memoryStore) Purge(id string, ifRev revision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.byID[id]
+	if err := purgeCheck(cur, ok, ifRev); err != nil {
+		return err
+	}
+	s.remove(id)
+	return nil
+}
+
+func (s *memoryStore) apply(a album) error {
+	s.put(a)
+	return nil
+}
+
+func (s *memoryStore) discard(id string) error {
+	s.drop(id)
+	return nil
+}
+
+func (s *memoryStore) reset(albums []album) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.byID = make(map[string]album, len(albums))
+	s.order = nil
+	s.seq = 0
+	for _, a := range albums {
+		s.putLocked(a)
+	}
+	return nil
+}
+
+// lastSeq returns the highest Seq assigned so far, which purged albums
+// may have held.
+func (s *memoryStore) lastSeq() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.seq
+}
+
+// skipSeq makes the Seqs assigned from now on greater than seq.
+func (s *memoryStore) skipSeq(seq uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq = max(s.seq, seq)
+}
+
+// remove drops id from the store. The caller must hold s.mu.
+func (s *memoryStore) remove(id string) {
+	delete(s.byID, id)
+	for i, v := range s.order {
+		if v == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// lookup returns the album stored under id, including soft-deleted ones.
+func (s *memoryStore) lookup(id string) (album, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	a, ok := s.byID[id]
+	return a, ok
+}
+
+// drop removes an album if present, ignoring unknown IDs.
+func (s *memoryStore) drop(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(id)
+}
+
+// put inserts or replaces an album without any checks and returns it as
+// stored.
+func (s *memoryStore) put(a album) album {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.putLocked(a)
+}
+
+// putAll stores each album as put does, all at once.
+func (s *memoryStore) putAll(albums []album) []album {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]album, len(albums))
+	for i, a := range albums {
+		out[i] = s.putLocked(a)
+	}
+	return out
+}
+
+// putLocked stores a and returns it as stored. A replaced album keeps its
+// Seq and position; a new album without a Seq is assigned the next one,
+// and one without a Version starts at 1. The caller must hold s.mu.
+func (s *memoryStore) putLocked(a album) album {
+	if a.Version == 0 {
+		a.Version = 1
+	}
+	if cur, ok := s.byID[a.ID]; ok {
+		a.Seq = cur.Seq
+		s.byID[a.ID] = a
+		return a
+	}
+	if a.Seq == 0 {
+		a.Seq = s.seq + 1
+	}
+	s.seq = max(s.seq, a.Seq)
+	s.byID[a.ID] = a
+
+	// Keep order sorted by Seq; new albums nearly always go at the end.
+	i := len(s.order)
+	for i > 0 && s.byID[s.order[i-1]].Seq > a.Seq {
+		i--
+	}
+	s.order = slices.Insert(s.order, i, a.ID)
+	return a
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCreateBatch(t *testing.T) {
+	stores := map[string]func(t *testing.T) AlbumStore{
+		"memory": func(t *testing.T) AlbumStore {
+			return newMemoryStore([]album{{ID: "1", Title: "Blue Train"}})
+		},
+		"file": func(t *testing.T) AlbumStore {
+			s, err := openFileStore(t.TempDir(), []album{{ID: "1", Title: "Blue Train"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { s.Close() })
+			return s
+		},
+	}
+	tests := []struct {
+		name      string
+		ids       []string
+		wantIndex int // of the album failing with errDuplicateID; -1 for none
+	}{
+		{"new albums", []string{"2", "3", "4"}, -1},
+		{"existing ID", []string{"2", "1", "3"}, 1},
+		{"repeated ID", []string{"2", "3", "2"}, 2},
+	}
+	for name, open := range stores {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				s := open(t)
+				batch := make([]album, len(tt.ids))
+				for i, id := range tt.ids {
+					batch[i] = album{ID: id, Title: "Album " + id, Version: 7}
+				}
+				got, err := s.CreateBatch(batch)
+				list, _ := s.List()
+
+				if tt.wantIndex >= 0 {
+					var be *batchError
+					if !errors.As(err, &be) || be.index != tt.wantIndex || !errors.Is(err, errDuplicateID) {
+						t.Fatalf("got error %v, want a duplicate ID at %d", err, tt.wantIndex)
+					}
+					if len(list) != 1 {
+						t.Errorf("got %d albums after a failed batch, want 1", len(list))
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(list) != 1+len(tt.ids) {
+					t.Fatalf("got %d albums, want %d", len(list), 1+len(tt.ids))
+				}
+				for i, a := range got {
+					if a.ID != tt.ids[i] || a.Version != 1 || a.Seq <= list[0].Seq {
+						t.Errorf("album %d stored as %+v", i, a)
+					}
+					if list[i+1] != a {
+						t.Errorf("listed %+v at %d, want %+v", list[i+1], i+1, a)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestFileStoreReplaysBatch(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openFileStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateBatch([]album{{ID: "1"}, {ID: "2"}}); err != nil {
+		t.Fatal(err)
+	}
+	// Reopen without the snapshot that Close writes, so the log is replayed.
+	s.log.Close()
+
+	s, err = openFileStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	list, _ := s.List()
+	if len(list) != 2 || list[0].ID != "1" || list[1].ID != "2" || list[1].Seq != 2 {
+		t.Errorf("got %+v after replay, want albums 1 and 2", list)
+	}
+}
+
+// testListAfter checks that s, holding no albums, pages through its live
+// albums in creation order.
+func testListAfter(t *testing.T, s AlbumStore) {
+	t.Helper()
+	var seqs []uint64
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		a, err := s.Create(album{ID: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		seqs = append(seqs, a.Seq)
+	}
+	if _, err := s.Delete("3", anyRevision); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		after uint64
+		limit int
+		want  string
+	}{
+		{0, 2, "1,2"},
+		{seqs[1], 2, "4,5"}, // skips the deleted album
+		{seqs[0], 10, "2,4,5"},
+		{seqs[4], 2, ""},
+	}
+	for _, tt := range tests {
+		page, err := s.ListAfter(tt.after, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, a := range page {
+			ids = append(ids, a.ID)
+		}
+		if got := strings.Join(ids, ","); got != tt.want {
+			t.Errorf("ListAfter(%d, %d) = %s, want %s", tt.after, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestListAfter(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		testListAfter(t, newMemoryStore(nil))
+	})
+	t.Run("file", func(t *testing.T) {
+		s, err := openFileStore(t.TempDir(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		testListAfter(t, s)
+	})
+}
+
+func TestFileStoreKeepsPurgedSeq(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openFileStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateBatch([]album{{ID: "1"}, {ID: "2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Purge("2", anyRevision); err != nil {
+		t.Fatal(err)
+	}
+	// Close compacts, so the purged album is in neither file.
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = openFileStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	a, err := s.Create(album{ID: "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Seq != 3 {
+		t.Errorf("new album got Seq %d, want 3 after the purged album's 2", a.Seq)
+	}
+}
+
+func TestFileStoreLoadsLegacySnapshot(t *testing.T) {
+	dir := t.TempDir()
+	legacy := `[{"id":"1","title":"Blue Train","price":"56.99","version":1,"seq":4}]`
+	if err := os.WriteFile(filepath.Join(dir, snapshotFile), []byte(legacy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := openFileStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if a, err := s.Get("1"); err != nil || a.Title != "Blue Train" || a.Seq != 4 {
+		t.Errorf("Get(1) = %+v, %v", a, err)
+	}
+	if a, err := s.Create(album{ID: "2"}); err != nil || a.Seq != 5 {
+		t.Errorf("Create(2) = %+v, %v, want Seq 5", a, err)
+	}
+}
@@ -44,4 +44,10 @@ except requests.exceptions.RequestException as e:
 
 data_from_both(EC2_URL1, EC2_URL2)
 
-print("uhoh... what happened?")
\ No newline at end of file
+print("\n\nand checking replication...")
+for url in (EC2_URL1, EC2_URL2):
+    try:
+        response = requests.get(f"{url}/{POST_DATA['id']}")
+        print(f"{url}/{POST_DATA['id']}: {response.status_code}")
+    except requests.exceptions.RequestException as e:
+        print(f"Error occurred while testing {url}: {e}")
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Album field limits.
+const (
+	maxIDLength     = 64
+	maxTitleLength  = 200
+	maxArtistLength = 200
+)
+
+// validID matches the album IDs allowed: those made of characters that
+// need no escaping in a URL path segment and starting with a letter or
+// digit, which rules out the dot segments "." and ".." that clients and
+// proxies remove from URL paths.
+var validID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._~-]*$`)
+
+// reservedIDs cannot be album IDs: they name the routes below /albums,
+// which /albums/{id} would reach rather than the album.
+var reservedIDs = []string{"trash", "search", "events"}
+
+// fieldError describes one invalid field of a request body.
+type fieldError struct {
+	Field   string `json:"field" xml:"name,attr"`
+	Code    string `json:"code" xml:"code,attr"`
+	Message string `json:"message" xml:",chardata"`
+}
+
+// validationErrors is every fieldError found in one album.
+type validationErrors []fieldError
+
+func (v validationErrors) Error() string {
+	msgs := make([]string, len(v))
+	for i, fe := range v {
+		msgs[i] = fe.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// MarshalXML writes each fieldError as a <field> element.
+func (v validationErrors) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(struct {
+		Fields []fieldError `xml:"field"`
+	}{v}, start)
+}
+
+// validateAlbum checks every field of the album data and returns a
+// validationErrors listing each problem found, or nil if it is valid.
+// A valid album's currency is normalized and its price put in minor units.
+func validateAlbum(a *album) error {
+	var errs validationErrors
+	add := func(field, code, format string, args ...any) {
+		errs = append(errs, fieldError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch {
+	case strings.TrimSpace(a.ID) == "":
+		add("id", "required", "album ID is required and cannot be empty")
+	case utf8.RuneCountInString(a.ID) > maxIDLength:
+		add("id", "too_long", "album ID cannot be longer than %d characters", maxIDLength)
+	case !validID.MatchString(a.ID):
+		add("id", "invalid_characters", "album ID must start with a letter or digit and may only contain letters, digits, '.', '_', '~' and '-'")
+	case slices.Contains(reservedIDs, a.ID):
+		add("id", "reserved", "album ID '%s' is reserved", a.ID)
+	}
+
+	switch {
+	case strings.TrimSpace(a.Title) == "":
+		add("title", "required", "album title is required and cannot be empty")
+	case utf8.RuneCountInString(a.Title) > maxTitleLength:
+		add("title", "too_long", "album title cannot be longer than %d characters", maxTitleLength)
+	}
+
+	switch {
+	case strings.TrimSpace(a.Artist) == "":
+		add("artist", "required", "album artist is required and cannot be empty")
+	case utf8.RuneCountInString(a.Artist) > maxArtistLength:
+		add("artist", "too_long", "album artist cannot be longer than %d characters", maxArtistLength)
+	}
+
+	a.Currency = strings.ToUpper(strings.TrimSpace(a.Currency))
+	if a.Currency == "" {
+		a.Currency = defaultCurrency
+	}
+	digits, known := minorDigits[a.Currency]
+	if !known {
+		add("currency", "unsupported_currency", "album currency %q is not a supported ISO 4217 code", a.Currency)
+	}
+
+	// Prices are kept in the minor units of their currency.
+	minor, exact := a.Price.rescale(digits)
+	switch {
+	case a.Price.sign() <= 0:
+		add("price", "must_be_positive", "album price must be greater than zero")
+	case a.Price.cmp(amount{units: maxPrice(a.Currency)}) > 0:
+		add("price", "too_large", "album prices in %s cannot be more than %d", a.Currency, maxPrice(a.Currency))
+	case known && !exact:
+		add("price", "too_precise", "album prices in %s cannot have more than %d decimal places", a.Currency, digits)
+	case known:
+		a.Price = minor
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// fieldErrors returns the per-field details of a validateAlbum error.
+func fieldErrors(err error) validationErrors {
+	var errs validationErrors
+	if errors.As(err, &errs) {
+		return errs
+	}
+	return nil
+}
+
+// respondValidationError reports an album that failed validateAlbum.
+func respondValidationError(c *gin.Context, err error) {
+	render(c, http.StatusBadRequest, errorResponse{
+		Error:   "validation_error",
+		Message: err.Error(),
+		Details: fieldErrors(err),
+	})
+}
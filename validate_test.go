@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestValidateAlbum(t *testing.T) {
+	tests := []struct {
+		id   string
+		want string // the id code, or "" if valid
+	}{
+		{"1", ""},
+		{"01J9Z3V4Q6M8X1T2B5C7D9F0GH", ""},
+		{"blue-train_1964.v2~x", ""},
+		{"", "required"},
+		{strings.Repeat("a", maxIDLength+1), "too_long"},
+		{"blue train", "invalid_characters"},
+		{"a/b", "invalid_characters"},
+		{".", "invalid_characters"},
+		{"..", "invalid_characters"},
+		{".hidden", "invalid_characters"},
+		{"-1", "invalid_characters"},
+		{"trash", "reserved"},
+		{"trash2", ""},
+	}
+	for _, tt := range tests {
+		a := album{ID: tt.id, Title: "T", Artist: "A", Price: amount{units: 1}}
+		var got string
+		if errs := fieldErrors(validateAlbum(&a)); len(errs) > 0 {
+			got = errs[0].Code
+		}
+		if got != tt.want {
+			t.Errorf("validateAlbum(id %q) code = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestPostAlbumValidationDetails(t *testing.T) {
+	h := newTestApp(t, testConfig(), nil).router
+	body := `{"id":"a b","title":"","artist":"` + strings.Repeat("x", maxArtistLength+1) + `","price":"-1","currency":"XXX"}`
+
+	e := wantError(t, send(h, http.MethodPost, "/albums", body), http.StatusBadRequest, "validation_error")
+	var got []string
+	for _, d := range e.Details {
+		got = append(got, d.Field+":"+d.Code)
+	}
+	want := "id:invalid_characters,title:required,artist:too_long,currency:unsupported_currency,price:must_be_positive"
+	if strings.Join(got, ",") != want {
+		t.Errorf("got details %v, want %s", got, want)
+	}
+
+	// Other response types carry the same details.
+	w := send(h, http.MethodPost, "/albums", body, "Accept", "application/xml")
+	wantStatus(t, w, http.StatusBadRequest)
+	for _, d := range e.Details {
+		if !strings.Contains(w.Body.String(), `<field name="`+d.Field+`" code="`+d.Code+`">`) {
+			t.Errorf("XML lacks the %s detail for %s: %s", d.Code, d.Field, w.Body)
+		}
+	}
+}
@@ -0,0 +1,778 @@
+package main
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	mrand "math/rand/v2"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// webhookWorkers is how many deliveries are sent at once.
+	webhookWorkers = 4
+
+	// webhookTimeout bounds one delivery attempt.
+	webhookTimeout = 10 * time.Second
+
+	// webhookMaxAttempts is how many times a delivery is tried before it
+	// is moved to the dead-letter list.
+	webhookMaxAttempts = 8
+
+	// webhookBackoff is the wait before the first retry. It doubles after
+	// every failed attempt, up to webhookMaxBackoff.
+	webhookBackoff    = time.Second
+	webhookMaxBackoff = 5 * time.Minute
+
+	// webhookLogSize is how many recent deliveries are kept per webhook.
+	webhookLogSize = 100
+
+	// deadLetterSize is how many failed deliveries are kept.
+	deadLetterSize = 1000
+
+	// maxQueuedDeliveries is how many deliveries may wait to be sent.
+	// New ones are dead-lettered while the queue is full.
+	maxQueuedDeliveries = 10000
+
+	// minWebhookSecret is the shortest signing secret a client may choose.
+	minWebhookSecret = 16
+
+	// webhooksFile is the file in the data directory that the file
+	// storage backend keeps webhooks in.
+	webhooksFile = "webhooks.json"
+)
+
+// Delivery states.
+const (
+	deliveryPending   = "pending"
+	deliverySucceeded = "succeeded"
+	deliveryFailed    = "failed"
+)
+
+// webhookEvents are the event types a webhook can subscribe to.
+var webhookEvents = []string{eventCreated, eventUpdated, eventDeleted}
+
+// webhookIDs generates webhook and delivery IDs.
+var webhookIDs ulidGenerator
+
+// errInternalDestination is returned for webhook deliveries to addresses
+// that only reach this host or its network.
+var errInternalDestination = errors.New("webhooks may not deliver to loopback, private or link-local addresses")
+
+// internalPrefixes are the special-purpose ranges, besides those netip
+// classifies, that webhooks may not deliver to.
+var internalPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),  // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),   // reserved, and broadcast
+}
+
+// internalAddr reports whether ip only reaches this host or its network.
+func internalAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, p := range internalPrefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// internalHost reports whether a URL's host is localhost or an internal
+// address. Other host names are checked once resolved, when dialed.
+func internalHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && internalAddr(ip)
+}
+
+// refuseInternal is a net.Dialer Control function that refuses to connect
+// to internal addresses. Checking the address actually dialed, rather
+// than the URL, also covers host names resolving to one and redirects.
+func refuseInternal(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if internalAddr(ip) {
+		return fmt.Errorf("%w: %s", errInternalDestination, ip)
+	}
+	return nil
+}
+
+// webhook is a subscription to album change events.
+type webhook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"` // only shown on creation
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// webhookRequest is the body of a request to create a webhook. Events
+// defaults to every event type and Secret to a random one.
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+// deliveryAttempt is one try at sending a delivery.
+type deliveryAttempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// delivery is one event sent, or to be sent, to one webhook.
+type delivery struct {
+	ID          string            `json:"id"`
+	WebhookID   string            `json:"webhook_id"`
+	Event       string            `json:"event"`
+	EventID     uint64            `json:"event_id"`
+	Status      string            `json:"status"`
+	Attempts    []deliveryAttempt `json:"attempts"`
+	NextAttempt *time.Time        `json:"next_attempt,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+
+	body  []byte // the payload, signed afresh on every attempt
+	tries int    // attempts since the delivery was last queued
+}
+
+// view returns a copy of dl that is safe to render without the
+// dispatcher's lock.
+func (dl *delivery) view() delivery {
+	v := *dl
+	v.Attempts = slices.Clone(dl.Attempts)
+	if dl.NextAttempt != nil {
+		t := *dl.NextAttempt
+		v.NextAttempt = &t
+	}
+	return v
+}
+
+// webhookPayload is the body POSTed to a webhook. Data is as in the
+// change feed.
+type webhookPayload struct {
+	Delivery string `json:"delivery"`
+	Event    string `json:"event"`
+	EventID  uint64 `json:"event_id"`
+	Data     any    `json:"data"`
+}
+
+// webhookList and deliveryList are response envelopes.
+type webhookList struct {
+	Webhooks []webhook `json:"webhooks"`
+}
+
+type deliveryList struct {
+	Deliveries []delivery `json:"deliveries"`
+}
+
+// webhookState is the content of the webhooks file.
+type webhookState struct {
+	Webhooks    []webhook       `json:"webhooks"`
+	DeadLetters []savedDelivery `json:"dead_letters"`
+}
+
+// savedDelivery is a delivery as saved in the webhooks file, along with
+// the payload to send if it is retried.
+type savedDelivery struct {
+	delivery
+	Body json.RawMessage `json:"body"`
+}
+
+// deliveryQueue is a heap of pending deliveries, soonest attempt first.
+type deliveryQueue []*delivery
+
+func (q deliveryQueue) Len() int           { return len(q) }
+func (q deliveryQueue) Less(i, j int) bool { return q[i].NextAttempt.Before(*q[j].NextAttempt) }
+func (q deliveryQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *deliveryQueue) Push(x any)        { *q = append(*q, x.(*delivery)) }
+
+func (q *deliveryQueue) Pop() any {
+	old := *q
+	dl := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return dl
+}
+
+// webhookDispatcher keeps the webhook subscriptions and delivers every
+// change recorded in a changeLog to the webhooks subscribed to its event
+// type. Each delivery is POSTed as JSON, signed with the webhook's
+// secret, and retried with exponential backoff until the webhook answers
+// 2xx or webhookMaxAttempts is reached, when it moves to the dead-letter
+// list.
+//
+// Subscriptions and dead letters are saved to a file, if given one, and
+// are otherwise lost on restart, as are pending deliveries and delivery
+// logs, which are only kept in memory. In a cluster, the leader keeps
+// them and followers forward webhook requests; instances sharing an s3
+// catalog each keep their own.
+type webhookDispatcher struct {
+	log          *changeLog
+	client       *http.Client
+	allowPrivate bool   // deliver to internal addresses too
+	path         string // the webhooks file, if any
+
+	ctx  context.Context // canceled on shutdown
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+	wake chan struct{} // signaled when a delivery may be due sooner
+
+	mu          sync.Mutex
+	hooks       map[string]*webhook
+	deliveries  map[string][]*delivery // by webhook ID, oldest first
+	queue       deliveryQueue
+	deadLetters []*delivery // oldest first
+}
+
+// newWebhookDispatcher returns a dispatcher for the changes in log that
+// refuses to deliver to internal addresses unless allowPrivate is set.
+// If path is set, the webhooks saved there are loaded and later changes
+// saved.
+func newWebhookDispatcher(log *changeLog, allowPrivate bool, path string) (*webhookDispatcher, error) {
+	dialer := &net.Dialer{Timeout: webhookTimeout, KeepAlive: 30 * time.Second}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if !allowPrivate {
+		dialer.Control = refuseInternal
+		// A proxy would dial for us, unchecked.
+		transport.Proxy = nil
+	}
+	transport.DialContext = dialer.DialContext
+	d := &webhookDispatcher{
+		log:          log,
+		client:       &http.Client{Timeout: webhookTimeout, Transport: transport},
+		allowPrivate: allowPrivate,
+		path:         path,
+		wake:         make(chan struct{}, 1),
+		hooks:        make(map[string]*webhook),
+		deliveries:   make(map[string][]*delivery),
+	}
+	d.ctx, d.stop = context.WithCancel(context.Background())
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// load reads the webhooks file, if there is one.
+func (d *webhookDispatcher) load() error {
+	if d.path == "" {
+		return nil
+	}
+	b, err := os.ReadFile(d.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var st webhookState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return fmt.Errorf("read %s: %w", d.path, err)
+	}
+	for _, h := range st.Webhooks {
+		d.hooks[h.ID] = &h
+	}
+	for _, sd := range st.DeadLetters {
+		dl := sd.delivery
+		dl.body = sd.Body
+		d.deadLetters = append(d.deadLetters, &dl)
+	}
+	return nil
+}
+
+// save writes the webhooks and dead letters to the webhooks file, if
+// there is one. Only its owner may read it, as it holds the signing
+// secrets. The caller must hold d.mu.
+func (d *webhookDispatcher) save() error {
+	if d.path == "" {
+		return nil
+	}
+	st := webhookState{Webhooks: make([]webhook, 0, len(d.hooks)), DeadLetters: make([]savedDelivery, len(d.deadLetters))}
+	for _, h := range d.hooks {
+		st.Webhooks = append(st.Webhooks, *h)
+	}
+	for i, dl := range d.deadLetters {
+		st.DeadLetters[i] = savedDelivery{delivery: dl.view(), Body: dl.body}
+	}
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return writeFileSync(d.path, b, 0o600)
+}
+
+// start begins following the change log and sending deliveries.
+func (d *webhookDispatcher) start() {
+	d.wg.Add(1 + webhookWorkers)
+	go func() {
+		defer d.wg.Done()
+		d.follow()
+	}()
+	for range webhookWorkers {
+		go func() {
+			defer d.wg.Done()
+			d.work()
+		}()
+	}
+}
+
+// shutdown stops delivering, abandoning pending deliveries.
+func (d *webhookDispatcher) shutdown() {
+	d.stop()
+	d.wg.Wait()
+}
+
+// follow queues a delivery of every new change for each webhook
+// subscribed to it.
+func (d *webhookDispatcher) follow() {
+	_, after := d.log.position()
+	for d.ctx.Err() == nil {
+		changes, ok := d.log.since(after, feedBatch)
+		if !ok {
+			_, last := d.log.position()
+			if d.subscribed() {
+				log.Printf("webhooks: changes after %d are no longer held; skipping to %d", after, last)
+			}
+			after = last
+			continue
+		}
+		for _, c := range changes {
+			d.enqueue(c)
+			after = c.Index
+		}
+		if len(changes) < feedBatch {
+			d.log.wait(d.ctx, after)
+		}
+	}
+}
+
+func (d *webhookDispatcher) subscribed() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.hooks) > 0
+}
+
+func (d *webhookDispatcher) enqueue(c change) {
+	event, data := changeEvent(c)
+	now := time.Now().UTC()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, h := range d.hooks {
+		if !slices.Contains(h.Events, event) {
+			continue
+		}
+		dl := &delivery{
+			ID:          webhookIDs.next(now),
+			WebhookID:   h.ID,
+			Event:       event,
+			EventID:     c.Index,
+			Status:      deliveryPending,
+			Attempts:    []deliveryAttempt{},
+			NextAttempt: &now,
+			CreatedAt:   now,
+		}
+		dl.body, _ = json.Marshal(webhookPayload{Delivery: dl.ID, Event: event, EventID: c.Index, Data: data})
+		d.record(dl)
+		if len(d.queue) >= maxQueuedDeliveries {
+			dl.Status, dl.NextAttempt = deliveryFailed, nil
+			dl.Attempts = append(dl.Attempts, deliveryAttempt{At: now, Error: "too many deliveries are waiting to be sent"})
+			d.deadLetter(dl)
+			continue
+		}
+		heap.Push(&d.queue, dl)
+	}
+	d.signal()
+}
+
+// record adds dl to its webhook's delivery log. The caller must hold d.mu.
+func (d *webhookDispatcher) record(dl *delivery) {
+	l := append(d.deliveries[dl.WebhookID], dl)
+	d.deliveries[dl.WebhookID] = l[max(0, len(l)-webhookLogSize):]
+}
+
+// signal wakes a waiting worker.
+func (d *webhookDispatcher) signal() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// work sends deliveries as they fall due until shutdown.
+func (d *webhookDispatcher) work() {
+	for {
+		dl, h, wait := d.next()
+		if dl == nil {
+			select {
+			case <-d.ctx.Done():
+				return
+			case <-d.wake:
+			case <-time.After(wait):
+			}
+			continue
+		}
+		d.attempt(dl, h)
+	}
+}
+
+// next takes the next due delivery off the queue, with a copy of its
+// webhook, or returns how long until one falls due.
+func (d *webhookDispatcher) next() (*delivery, webhook, time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for len(d.queue) > 0 {
+		dl := d.queue[0]
+		if wait := time.Until(*dl.NextAttempt); wait > 0 {
+			return nil, webhook{}, wait
+		}
+		heap.Pop(&d.queue)
+		h, ok := d.hooks[dl.WebhookID]
+		if !ok {
+			continue // deleted since
+		}
+		if len(d.queue) > 0 && !d.queue[0].NextAttempt.After(time.Now()) {
+			d.signal() // let another worker take the next one
+		}
+		return dl, *h, 0
+	}
+	return nil, webhook{}, time.Hour
+}
+
+// attempt sends dl to h once, then records the outcome and schedules a
+// retry or gives up.
+func (d *webhookDispatcher) attempt(dl *delivery, h webhook) {
+	at := deliveryAttempt{At: time.Now().UTC()}
+	status, err := d.send(dl, h)
+	at.StatusCode = status
+	if err != nil {
+		at.Error = err.Error()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dl.Attempts = append(dl.Attempts, at)
+	dl.tries++
+	switch {
+	case err == nil:
+		dl.Status, dl.NextAttempt = deliverySucceeded, nil
+	case dl.tries >= webhookMaxAttempts:
+		dl.Status, dl.NextAttempt = deliveryFailed, nil
+		d.deadLetter(dl)
+	default:
+		t := time.Now().UTC().Add(webhookRetryDelay(dl.tries))
+		dl.NextAttempt = &t
+		heap.Push(&d.queue, dl)
+	}
+}
+
+// deadLetter adds dl to the dead-letter list. The caller must hold d.mu.
+func (d *webhookDispatcher) deadLetter(dl *delivery) {
+	d.deadLetters = append(d.deadLetters, dl)
+	d.deadLetters = d.deadLetters[max(0, len(d.deadLetters)-deadLetterSize):]
+	if err := d.save(); err != nil {
+		log.Printf("webhooks: save dead letter %s: %v", dl.ID, err)
+	}
+}
+
+// webhookRetryDelay returns the wait after the given number of failed
+// attempts, with up to 20% added so that retries to a recovering
+// receiver spread out.
+func webhookRetryDelay(failures int) time.Duration {
+	delay := webhookMaxBackoff
+	if failures <= 16 {
+		delay = min(webhookBackoff<<(failures-1), webhookMaxBackoff)
+	}
+	return delay + mrand.N(delay/5+1)
+}
+
+// send POSTs dl to h, returning the status the webhook answered with, if
+// any, and an error unless it was 2xx.
+func (d *webhookDispatcher) send(dl *delivery, h webhook) (int, error) {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, h.URL, bytes.NewReader(dl.body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "album-webhooks/1")
+	req.Header.Set("X-Webhook-Id", h.ID)
+	req.Header.Set("X-Webhook-Delivery", dl.ID)
+	req.Header.Set("X-Webhook-Event", dl.Event)
+	req.Header.Set("X-Webhook-Timestamp", ts)
+	req.Header.Set("X-Webhook-Signature", "sha256="+signWebhook(h.Secret, ts, dl.body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// signWebhook returns the hex HMAC-SHA256, keyed with secret, of the
+// timestamp and body joined by a dot. Receivers recompute it to check a
+// delivery came from this service, and reject stale timestamps to stop
+// replays.
+func signWebhook(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validateWebhook checks a request to create a webhook, defaulting its
+// event types, and returns a validationErrors listing each problem found.
+// Unless allowPrivate is set, a URL naming an internal address is
+// refused up front; one whose host name resolves to one fails to deliver.
+func validateWebhook(r *webhookRequest, allowPrivate bool) error {
+	var errs validationErrors
+	add := func(field, code, format string, args ...any) {
+		errs = append(errs, fieldError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if r.URL == "" {
+		add("url", "required", "url is required")
+	} else if u, err := url.Parse(r.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		add("url", "invalid_url", "url must be an absolute http or https URL")
+	} else if !allowPrivate && internalHost(u.Hostname()) {
+		add("url", "internal_destination", "%v", errInternalDestination)
+	}
+
+	if len(r.Events) == 0 {
+		r.Events = slices.Clone(webhookEvents)
+	}
+	var events []string
+	for _, e := range r.Events {
+		switch {
+		case !slices.Contains(webhookEvents, e):
+			add("events", "unknown_event", "unknown event type %q (want created, updated or deleted)", e)
+		case !slices.Contains(events, e):
+			events = append(events, e)
+		}
+	}
+	r.Events = events
+
+	if r.Secret != "" && len(r.Secret) < minWebhookSecret {
+		add("secret", "too_short", "secret must be at least %d characters", minWebhookSecret)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func respondWebhookNotFound(c *gin.Context, id string) {
+	render(c, http.StatusNotFound, errorResponse{
+		Error:   "not_found",
+		Message: fmt.Sprintf("webhook with ID '%s' not found", id),
+	})
+}
+
+// createWebhook subscribes a URL to album change events. The response
+// is the only one to include the webhook's signing secret.
+func (d *webhookDispatcher) createWebhook(c *gin.Context) {
+	var req webhookRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		render(c, http.StatusBadRequest, errorResponse{
+			Error:   "invalid_request",
+			Message: fmt.Sprintf("failed to parse request body: %v", err),
+		})
+		return
+	}
+	if err := validateWebhook(&req, d.allowPrivate); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+	if req.Secret == "" {
+		b := make([]byte, 32)
+		rand.Read(b)
+		req.Secret = hex.EncodeToString(b)
+	}
+
+	now := time.Now().UTC()
+	h := &webhook{ID: webhookIDs.next(now), URL: req.URL, Events: req.Events, Secret: req.Secret, CreatedAt: now}
+	d.mu.Lock()
+	d.hooks[h.ID] = h
+	if err := d.save(); err != nil {
+		delete(d.hooks, h.ID)
+		d.mu.Unlock()
+		respondStorageError(c, err)
+		return
+	}
+	d.mu.Unlock()
+
+	c.Header("Location", "/webhooks/"+h.ID)
+	render(c, http.StatusCreated, *h)
+}
+
+// getWebhooks lists every webhook, oldest first, without their secrets.
+func (d *webhookDispatcher) getWebhooks(c *gin.Context) {
+	d.mu.Lock()
+	list := webhookList{Webhooks: make([]webhook, 0, len(d.hooks))}
+	for _, h := range d.hooks {
+		v := *h
+		v.Secret = ""
+		list.Webhooks = append(list.Webhooks, v)
+	}
+	d.mu.Unlock()
+	// IDs are ULIDs, so they sort by creation time.
+	slices.SortFunc(list.Webhooks, func(a, b webhook) int { return strings.Compare(a.ID, b.ID) })
+	render(c, http.StatusOK, list)
+}
+
+func (d *webhookDispatcher) getWebhook(c *gin.Context) {
+	id := c.Param("id")
+	d.mu.Lock()
+	h, ok := d.hooks[id]
+	var v webhook
+	if ok {
+		v = *h
+		v.Secret = ""
+	}
+	d.mu.Unlock()
+	if !ok {
+		respondWebhookNotFound(c, id)
+		return
+	}
+	render(c, http.StatusOK, v)
+}
+
+// deleteWebhook unsubscribes a webhook, dropping its pending deliveries
+// and delivery log.
+func (d *webhookDispatcher) deleteWebhook(c *gin.Context) {
+	id := c.Param("id")
+	d.mu.Lock()
+	h, ok := d.hooks[id]
+	if !ok {
+		d.mu.Unlock()
+		respondWebhookNotFound(c, id)
+		return
+	}
+	delete(d.hooks, id)
+	if err := d.save(); err != nil {
+		d.hooks[id] = h
+		d.mu.Unlock()
+		respondStorageError(c, err)
+		return
+	}
+	delete(d.deliveries, id)
+	d.mu.Unlock()
+	c.Status(http.StatusNoContent)
+}
+
+// getDeliveries returns a webhook's recent deliveries, newest first.
+func (d *webhookDispatcher) getDeliveries(c *gin.Context) {
+	id := c.Param("id")
+	d.mu.Lock()
+	_, ok := d.hooks[id]
+	list := viewDeliveries(d.deliveries[id])
+	d.mu.Unlock()
+	if !ok {
+		respondWebhookNotFound(c, id)
+		return
+	}
+	render(c, http.StatusOK, list)
+}
+
+// getDeadLetters returns the deliveries that ran out of attempts, newest
+// first.
+func (d *webhookDispatcher) getDeadLetters(c *gin.Context) {
+	d.mu.Lock()
+	list := viewDeliveries(d.deadLetters)
+	d.mu.Unlock()
+	render(c, http.StatusOK, list)
+}
+
+// retryDeadLetter takes a delivery off the dead-letter list and queues
+// it again with a fresh set of attempts.
+func (d *webhookDispatcher) retryDeadLetter(c *gin.Context) {
+	id := c.Param("id")
+	d.mu.Lock()
+	i := slices.IndexFunc(d.deadLetters, func(dl *delivery) bool { return dl.ID == id })
+	if i < 0 {
+		d.mu.Unlock()
+		render(c, http.StatusNotFound, errorResponse{
+			Error:   "not_found",
+			Message: fmt.Sprintf("no dead-lettered delivery with ID '%s'", id),
+		})
+		return
+	}
+	dl := d.deadLetters[i]
+	if _, ok := d.hooks[dl.WebhookID]; !ok {
+		d.mu.Unlock()
+		respondWebhookNotFound(c, dl.WebhookID)
+		return
+	}
+	if len(d.queue) >= maxQueuedDeliveries {
+		d.mu.Unlock()
+		render(c, http.StatusServiceUnavailable, errorResponse{
+			Error:   "queue_full",
+			Message: "too many deliveries are waiting to be sent; retry later",
+		})
+		return
+	}
+	d.deadLetters = slices.Delete(d.deadLetters, i, i+1)
+	if err := d.save(); err != nil {
+		// It is sent all the same, and dead-lettered again on restart.
+		log.Printf("webhooks: save dead letters: %v", err)
+	}
+	now := time.Now().UTC()
+	dl.Status, dl.NextAttempt, dl.tries = deliveryPending, &now, 0
+	heap.Push(&d.queue, dl)
+	v := dl.view()
+	d.signal()
+	d.mu.Unlock()
+	render(c, http.StatusAccepted, v)
+}
+
+// viewDeliveries copies l, newest first. The caller must hold d.mu.
+func viewDeliveries(l []*delivery) deliveryList {
+	list := deliveryList{Deliveries: make([]delivery, 0, len(l))}
+	for i := len(l) - 1; i >= 0; i-- {
+		list.Deliveries = append(list.Deliveries, l[i].view())
+	}
+	return list
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestInternalAddr(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"127.0.0.1", true},
+		{"127.9.9.9", true},
+		{"::1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true}, // cloud metadata
+		{"fe80::1", true},
+		{"fc00::1", true},
+		{"0.0.0.0", true},
+		{"::", true},
+		{"100.64.0.1", true},
+		{"224.0.0.1", true},
+		{"255.255.255.255", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:169.254.169.254", true},
+		{"93.184.216.34", false},
+		{"172.32.0.1", false},
+		{"2606:2800:220:1:248:1893:25c8:1946", false},
+		{"::ffff:93.184.216.34", false},
+	}
+	for _, tt := range tests {
+		if got := internalAddr(netip.MustParseAddr(tt.ip)); got != tt.want {
+			t.Errorf("internalAddr(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	tests := []struct {
+		url          string
+		allowPrivate bool
+		wantCode     string
+	}{
+		{"https://hooks.example.com/albums", false, ""},
+		{"http://93.184.216.34:8080/", false, ""},
+		{"http://localhost:9100/", false, "internal_destination"},
+		{"http://LOCALHOST/", false, "internal_destination"},
+		{"http://127.0.0.1/", false, "internal_destination"},
+		{"http://[::1]:9100/", false, "internal_destination"},
+		{"http://169.254.169.254/latest/meta-data/", false, "internal_destination"},
+		{"http://10.0.0.5/", false, "internal_destination"},
+		{"http://127.0.0.1:9100/", true, ""},
+		{"ftp://hooks.example.com/", false, "invalid_url"},
+		{"/relative", false, "invalid_url"},
+	}
+	for _, tt := range tests {
+		r := webhookRequest{URL: tt.url}
+		var code string
+		if errs := fieldErrors(validateWebhook(&r, tt.allowPrivate)); len(errs) > 0 {
+			code = errs[0].Code
+		}
+		if code != tt.wantCode {
+			t.Errorf("validateWebhook(%s, allowPrivate=%v) code = %q, want %q", tt.url, tt.allowPrivate, code, tt.wantCode)
+		}
+	}
+}
+
+func TestWebhookClientRefusesInternal(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	// The server listens on loopback, as would a service reached through
+	// a host name resolving there.
+	d, err := newWebhookDispatcher(newChangeLog("", 0), false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.client.Get(srv.URL); !errors.Is(err, errInternalDestination) {
+		t.Errorf("got error %v, want %v", err, errInternalDestination)
+	}
+
+	d, err = newWebhookDispatcher(newChangeLog("", 0), true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := d.client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("with allowPrivate: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestWebhooksSurviveRestart(t *testing.T) {
+	dir := t.TempDir()
+	cfg := testConfig()
+	cfg.Storage, cfg.DataDir = "file", dir
+	store, err := openFileStore(dir, seedAlbums)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	a := newTestApp(t, cfg, store)
+	w := send(a.router, http.MethodPost, "/webhooks", `{"url":"https://hooks.example.com/albums","events":["created"]}`)
+	wantStatus(t, w, http.StatusCreated)
+	h := decode[webhook](t, w)
+
+	// A delivery that ran out of attempts.
+	body := []byte(`{"delivery":"d1","event":"created","event_id":7,"data":{"id":"7"}}`)
+	a.hooks.mu.Lock()
+	a.hooks.deadLetter(&delivery{ID: "d1", WebhookID: h.ID, Event: eventCreated, EventID: 7, Status: deliveryFailed, body: body})
+	a.hooks.mu.Unlock()
+
+	fi, err := os.Stat(filepath.Join(dir, webhooksFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0o600 {
+		t.Errorf("webhooks file has permissions %v, want 0600 since it holds secrets", perm)
+	}
+
+	b := newTestApp(t, cfg, store)
+	w = send(b.router, http.MethodGet, "/webhooks/"+h.ID, "")
+	wantStatus(t, w, http.StatusOK)
+	if got := decode[webhook](t, w); got.URL != h.URL || !slices.Equal(got.Events, h.Events) {
+		t.Errorf("got %+v after restart, want %+v", got, h)
+	}
+	if got := b.hooks.hooks[h.ID].Secret; got != h.Secret {
+		t.Errorf("got secret %q after restart, want %q", got, h.Secret)
+	}
+
+	w = send(b.router, http.MethodGet, "/webhooks/dead-letters", "")
+	wantStatus(t, w, http.StatusOK)
+	if l := decode[deliveryList](t, w); len(l.Deliveries) != 1 || l.Deliveries[0].ID != "d1" {
+		t.Errorf("got dead letters %+v after restart, want d1", l.Deliveries)
+	}
+	if got := b.hooks.deadLetters[0].body; !bytes.Equal(got, body) {
+		t.Errorf("got dead letter body %s after restart, want %s", got, body)
+	}
+
+	// Deleting a webhook is saved too.
+	wantStatus(t, send(b.router, http.MethodDelete, "/webhooks/"+h.ID, ""), http.StatusNoContent)
+	c := newTestApp(t, cfg, store)
+	wantError(t, send(c.router, http.MethodGet, "/webhooks/"+h.ID, ""), http.StatusNotFound, "not_found")
+}